@@ -0,0 +1,13 @@
+package apicutil_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestApicutil(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Apicutil Suite")
+}
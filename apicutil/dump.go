@@ -1,22 +1,136 @@
 package apicutil
 
 import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/http/httputil"
+	"strings"
 
 	"github.com/kolach/apic"
 )
 
-// WithDumpRequest writes request object
-func WithDumpRequest(w io.Writer, body bool) apic.InterceptDoFunc {
+// dumpConfig is dump interceptors configuration
+type dumpConfig struct {
+	limit  int
+	pretty bool
+}
+
+// DumpOptionFunc is functional type to configure dump interceptors
+type DumpOptionFunc func(cfg *dumpConfig)
+
+// WithDumpLimit limits dumped body to n bytes, rest of body is marked as truncated
+func WithDumpLimit(n int) DumpOptionFunc {
+	return func(cfg *dumpConfig) {
+		cfg.limit = n
+	}
+}
+
+// WithPrettyPrint makes dump indent JSON and XML bodies which fit into the limit
+func WithPrettyPrint() DumpOptionFunc {
+	return func(cfg *dumpConfig) {
+		cfg.pretty = true
+	}
+}
+
+// newDumpConfig constructs dump configuration
+func newDumpConfig(opts []DumpOptionFunc) *dumpConfig {
+	cfg := new(dumpConfig)
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// peek reads body for dump and returns body replacement yielding the same bytes again
+func (cfg *dumpConfig) peek(body io.ReadCloser) ([]byte, bool, io.ReadCloser, error) {
+	limit := cfg.limit
+	if limit <= 0 {
+		// peekBody reads one byte past the limit, keep room for it
+		limit = int(^uint(0)>>1) - 1
+	}
+	return peekBody(body, limit)
+}
+
+// format returns body prepared for dump
+func (cfg *dumpConfig) format(b []byte, truncated bool, contentType string) string {
+	if cfg.pretty && !truncated {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		switch {
+		case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+			var out bytes.Buffer
+			if json.Indent(&out, b, "", "  ") == nil {
+				return out.String()
+			}
+		case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+			if s, err := indentXML(b); err == nil {
+				return s
+			}
+		}
+	}
+	if truncated {
+		return fmt.Sprintf("%s\n... (truncated to %d bytes)", b, len(b))
+	}
+	return string(b)
+}
+
+// indentXML re-encodes XML document with indentation
+func indentXML(b []byte) (string, error) {
+	var out bytes.Buffer
+	dec := xml.NewDecoder(bytes.NewReader(b))
+	enc := xml.NewEncoder(&out)
+	enc.Indent("", "  ")
+	for {
+		t, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		// whitespace between elements is replaced by indentation
+		if cd, ok := t.(xml.CharData); ok && len(bytes.TrimSpace(cd)) == 0 {
+			continue
+		}
+		if err := enc.EncodeToken(t); err != nil {
+			return "", err
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// WithDumpRequest writes request object.
+// Body is dumped without consuming it, up to the limit if configured.
+// Dump failures are written to w instead of failing the request.
+// Usage example:
+//
+// res, err := c.Do(req, apicutil.WithDumpRequest(os.Stdout, true, apicutil.WithDumpLimit(4096), apicutil.WithPrettyPrint()))
+//
+func WithDumpRequest(w io.Writer, body bool, opts ...DumpOptionFunc) apic.InterceptDoFunc {
+	cfg := newDumpConfig(opts)
+
 	return func(do apic.DoFunc) apic.DoFunc {
 		return func(req *http.Request) (*http.Response, error) {
-			// Save a copy of this request for debugging.
-			reqDump, err := httputil.DumpRequest(req, body)
+			reqDump, err := httputil.DumpRequest(req, false)
 			if err != nil {
-				return nil, err
+				fmt.Fprintf(w, "failed to dump request: %v\n", err)
+				return do(req)
+			}
+			if body && req.Body != nil && req.Body != http.NoBody {
+				b, truncated, rest, err := cfg.peek(req.Body)
+				req.Body = rest
+				if err != nil {
+					fmt.Fprintf(w, "%s\nfailed to dump request body: %v\n", reqDump, err)
+					return do(req)
+				}
+				reqDump = append(reqDump, cfg.format(b, truncated, req.Header.Get("Content-Type"))...)
 			}
 			fmt.Fprintln(w, string(reqDump))
 			return do(req)
@@ -24,17 +138,41 @@ func WithDumpRequest(w io.Writer, body bool) apic.InterceptDoFunc {
 	}
 }
 
-// WithDumpResponse writes response object
-func WithDumpResponse(w io.Writer, body bool) apic.InterceptDoFunc {
+// WithDumpResponse writes response object or request error.
+// Body is dumped without consuming it, up to the limit if configured.
+// Usage example:
+//
+// res, err := c.Do(req, apicutil.WithDumpResponse(os.Stdout, true, apicutil.WithDumpLimit(4096)))
+//
+func WithDumpResponse(w io.Writer, body bool, opts ...DumpOptionFunc) apic.InterceptDoFunc {
+	cfg := newDumpConfig(opts)
+
 	return func(do apic.DoFunc) apic.DoFunc {
 		return func(req *http.Request) (*http.Response, error) {
 			res, err := do(req)
-			resDump, err := httputil.DumpResponse(res, body)
 			if err != nil {
-				return nil, err
+				fmt.Fprintf(w, "request failed: %v\n", err)
+				return res, err
+			}
+			if res == nil {
+				return nil, nil
+			}
+			resDump, err := httputil.DumpResponse(res, false)
+			if err != nil {
+				fmt.Fprintf(w, "failed to dump response: %v\n", err)
+				return res, nil
+			}
+			if body && res.Body != nil && res.Body != http.NoBody {
+				b, truncated, rest, err := cfg.peek(res.Body)
+				res.Body = rest
+				if err != nil {
+					fmt.Fprintf(w, "%s\nfailed to dump response body: %v\n", resDump, err)
+					return res, nil
+				}
+				resDump = append(resDump, cfg.format(b, truncated, res.Header.Get("Content-Type"))...)
 			}
 			fmt.Fprintln(w, string(resDump))
-			return res, err
+			return res, nil
 		}
 	}
 }
@@ -0,0 +1,85 @@
+package apicutil_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/kolach/apic"
+	. "github.com/kolach/apic/apicutil"
+)
+
+var _ = Describe("Dump", func() {
+	var (
+		out bytes.Buffer
+		req *http.Request
+	)
+
+	BeforeEach(func() {
+		out.Reset()
+		req, _ = http.NewRequest("POST", "https://example.com/orders", strings.NewReader(`{"id":1,"name":"iPhoneX"}`))
+		req.Header.Set("Content-Type", "application/json")
+	})
+
+	respond := func(contentType, body string) apic.DoFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Status:     "200 OK",
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Header:     http.Header{"Content-Type": {contentType}},
+				Body:       ioutil.NopCloser(strings.NewReader(body)),
+			}, nil
+		}
+	}
+
+	Describe("WithDumpRequest", func() {
+		It("should dump body without consuming it", func() {
+			var sent []byte
+			do := func(req *http.Request) (*http.Response, error) {
+				sent, _ = ioutil.ReadAll(req.Body)
+				return nil, nil
+			}
+			WithDumpRequest(&out, true, WithDumpLimit(7))(do)(req)
+			Ω(string(sent)).Should(Equal(`{"id":1,"name":"iPhoneX"}`))
+			Ω(out.String()).Should(ContainSubstring("POST /orders HTTP/1.1"))
+			Ω(out.String()).Should(ContainSubstring("{\"id\":1\n... (truncated to 7 bytes)"))
+		})
+
+		It("should pretty print JSON body", func() {
+			WithDumpRequest(&out, true, WithPrettyPrint())(respond("", ""))(req)
+			Ω(out.String()).Should(ContainSubstring("{\n  \"id\": 1,\n  \"name\": \"iPhoneX\"\n}"))
+		})
+	})
+
+	Describe("WithDumpResponse", func() {
+		It("should dump request error instead of panicking", func() {
+			do := func(req *http.Request) (*http.Response, error) {
+				return nil, fmt.Errorf("Error")
+			}
+			_, err := WithDumpResponse(&out, true)(do)(req)
+			Ω(err).Should(MatchError("Error"))
+			Ω(out.String()).Should(Equal("request failed: Error\n"))
+		})
+
+		It("should dump body without consuming it", func() {
+			res, err := WithDumpResponse(&out, true)(respond("text/plain", "test"))(req)
+			Ω(err).ShouldNot(HaveOccurred())
+			b, _ := ioutil.ReadAll(res.Body)
+			Ω(string(b)).Should(Equal("test"))
+			Ω(out.String()).Should(ContainSubstring("HTTP/1.1 200 OK"))
+			Ω(out.String()).Should(HaveSuffix("test\n"))
+		})
+
+		It("should pretty print XML body", func() {
+			WithDumpResponse(&out, true, WithPrettyPrint())(respond("application/xml", "<order><id>1</id></order>"))(req)
+			Ω(out.String()).Should(ContainSubstring("<order>\n  <id>1</id>\n</order>"))
+		})
+	})
+})
@@ -0,0 +1,372 @@
+package apicutil
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kolach/apic"
+)
+
+// HAR 1.2 document structure, see http://www.softwareishard.com/blog/har-12-spec/
+
+// HARLog is root of HAR document
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+// HARCreator describes application which created the log
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// HAREntry is single request attempt
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Attempt         int         `json:"_attempt,omitempty"`
+	Error           string      `json:"_error,omitempty"`
+}
+
+// HARNameValue is name-value pair of headers, cookies and query string
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HARRequest is request of entry
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+// HARPostData is request body
+type HARPostData struct {
+	MimeType  string `json:"mimeType"`
+	Text      string `json:"text"`
+	Truncated bool   `json:"_truncated,omitempty"`
+}
+
+// HARResponse is response of entry
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+// HARContent is response body
+type HARContent struct {
+	Size      int64  `json:"size"`
+	MimeType  string `json:"mimeType"`
+	Text      string `json:"text,omitempty"`
+	Truncated bool   `json:"_truncated,omitempty"`
+}
+
+// HARTimings is timing breakdown of entry in milliseconds, -1 if not applicable
+type HARTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// HAROptionFunc is functional type to configure HAR recorder
+type HAROptionFunc func(r *HARRecorder)
+
+// WithHARBodyLimit limits recorded bodies to n bytes, default is 64KB,
+// negative value disables body recording
+func WithHARBodyLimit(n int) HAROptionFunc {
+	return func(r *HARRecorder) {
+		r.bodyLimit = n
+	}
+}
+
+// WithHARRedaction configures redaction with the same options as WithLogger,
+// default redaction lists of WithLogger apply as well
+func WithHARRedaction(opts ...LogOptionFunc) HAROptionFunc {
+	return func(r *HARRecorder) {
+		r.redact = newLogConfig(opts)
+	}
+}
+
+// HARRecorder records traffic into HAR 1.2 log
+type HARRecorder struct {
+	bodyLimit int
+	redact    *logConfig
+
+	mu      sync.Mutex
+	entries []*HAREntry
+}
+
+// NewHARRecorder constructs HAR recorder
+func NewHARRecorder(opts ...HAROptionFunc) *HARRecorder {
+	r := &HARRecorder{bodyLimit: 64 << 10, redact: newLogConfig(nil)}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Log returns HAR log of recorded entries
+func (r *HARRecorder) Log() HARLog {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entries := make([]HAREntry, len(r.entries))
+	for i, e := range r.entries {
+		entries[i] = *e
+	}
+	return HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "apic", Version: "1.0"},
+		Entries: entries,
+	}
+}
+
+// WriteTo writes HAR document to w
+func (r *HARRecorder) WriteTo(w io.Writer) (int64, error) {
+	b, err := json.MarshalIndent(struct {
+		Log HARLog `json:"log"`
+	}{r.Log()}, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// WriteFile writes HAR document to file
+func (r *HARRecorder) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err = r.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// nameValues converts header to sorted name-value list with sensitive values redacted
+func (r *HARRecorder) nameValues(h http.Header) []HARNameValue {
+	list := make([]HARNameValue, 0, len(h))
+	for name, values := range h {
+		redacted := r.redact.redactHeaders[http.CanonicalHeaderKey(name)]
+		for _, v := range values {
+			if redacted {
+				v = Redacted
+			}
+			list = append(list, HARNameValue{Name: name, Value: v})
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// cookies converts cookies to name-value list, redacted if cookie header is
+func (r *HARRecorder) cookies(cookies []*http.Cookie, header string) []HARNameValue {
+	list := make([]HARNameValue, 0, len(cookies))
+	for _, c := range cookies {
+		v := c.Value
+		if r.redact.redactHeaders[header] {
+			v = Redacted
+		}
+		list = append(list, HARNameValue{Name: c.Name, Value: v})
+	}
+	return list
+}
+
+// body reads up to limit of body for recording and returns body replacement
+func (r *HARRecorder) body(body io.ReadCloser) (string, bool, io.ReadCloser, error) {
+	if r.bodyLimit < 0 || body == nil || body == http.NoBody {
+		return "", false, body, nil
+	}
+	b, truncated, rest, err := peekBody(body, r.bodyLimit)
+	if err != nil {
+		return "", false, rest, err
+	}
+	text := r.redact.redactBody(b, false)
+	return text, truncated, rest, nil
+}
+
+// request builds HAR request and returns request with body restored
+func (r *HARRecorder) request(req *http.Request) (HARRequest, *http.Request, error) {
+	u := r.redact.redactURL(req.URL)
+	hr := HARRequest{
+		Method:      req.Method,
+		URL:         u,
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     r.cookies(req.Cookies(), "Cookie"),
+		Headers:     r.nameValues(req.Header),
+		QueryString: []HARNameValue{},
+		HeadersSize: -1,
+		BodySize:    req.ContentLength,
+	}
+	if req.Proto != "" {
+		hr.HTTPVersion = req.Proto
+	}
+	if redacted, err := req.URL.Parse(u); err == nil {
+		for name, values := range redacted.Query() {
+			for _, v := range values {
+				hr.QueryString = append(hr.QueryString, HARNameValue{Name: name, Value: v})
+			}
+		}
+		sort.SliceStable(hr.QueryString, func(i, j int) bool { return hr.QueryString[i].Name < hr.QueryString[j].Name })
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		text, truncated, body, err := r.body(req.Body)
+		req = shallowCopy(req)
+		req.Body = body
+		if err != nil {
+			return hr, req, err
+		}
+		hr.PostData = &HARPostData{MimeType: req.Header.Get("Content-Type"), Text: text, Truncated: truncated}
+	}
+	return hr, req, nil
+}
+
+// shallowCopy copies request, so its body can be replaced without affecting caller
+func shallowCopy(req *http.Request) *http.Request {
+	r := new(http.Request)
+	*r = *req
+	return r
+}
+
+// milliseconds converts duration to HAR milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// timings converts attempt timing into HAR timings
+func timings(t apic.Timing) HARTimings {
+	ht := HARTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1}
+	if !t.Reused {
+		ht.DNS = milliseconds(t.DNS)
+		ht.Connect = milliseconds(t.Connect + t.TLS)
+		ht.SSL = milliseconds(t.TLS)
+	}
+	ttfb := t.TimeToFirstByte
+	if ttfb == 0 {
+		// transport doesn't report first byte, count the whole exchange as waiting
+		ttfb = t.Total
+	}
+	ht.Wait = milliseconds(ttfb - t.DNS - t.Connect - t.TLS)
+	if ht.Wait < 0 {
+		ht.Wait = 0
+	}
+	ht.Receive = milliseconds(t.Total - ttfb)
+	return ht
+}
+
+// WithHAR records every request attempt, including retries, into HAR recorder.
+// Response size and timings are recorded once response body is closed.
+// Usage example:
+//
+// har := apicutil.NewHARRecorder(apicutil.WithHARBodyLimit(4096))
+// ...
+// res, err := c.Do(req, apicutil.WithHAR(har))
+// ...
+// err = har.WriteFile("traffic.har")
+//
+func WithHAR(r *HARRecorder) apic.InterceptDoFunc {
+	return func(do apic.DoFunc) apic.DoFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			e := &HAREntry{StartedDateTime: time.Now(), Attempt: apic.RetryAttempt(req.Context())}
+			hr, req, err := r.request(req)
+			e.Request = hr
+			if err != nil {
+				return nil, err
+			}
+			r.mu.Lock()
+			r.entries = append(r.entries, e)
+			r.mu.Unlock()
+
+			timed := apic.WithTiming(func(t apic.Timing) {
+				r.mu.Lock()
+				defer r.mu.Unlock()
+				e.Time = milliseconds(t.Total)
+				e.Timings = timings(t)
+				if host, _, err := net.SplitHostPort(t.RemoteAddr); err == nil {
+					e.ServerIPAddress = host
+				}
+			})(do)
+
+			res, err := timed(req)
+			if err != nil {
+				r.mu.Lock()
+				e.Error = err.Error()
+				e.Response = HARResponse{Cookies: []HARNameValue{}, Headers: []HARNameValue{}, HeadersSize: -1, BodySize: -1}
+				r.mu.Unlock()
+				return res, err
+			}
+
+			hres := HARResponse{
+				Status:      res.StatusCode,
+				StatusText:  http.StatusText(res.StatusCode),
+				HTTPVersion: "HTTP/1.1",
+				Cookies:     r.cookies(res.Cookies(), "Set-Cookie"),
+				Headers:     r.nameValues(res.Header),
+				Content:     HARContent{Size: -1, MimeType: res.Header.Get("Content-Type")},
+				RedirectURL: res.Header.Get("Location"),
+				HeadersSize: -1,
+				BodySize:    -1,
+			}
+			if res.Proto != "" {
+				hres.HTTPVersion = res.Proto
+			}
+			if res.Body == nil {
+				hres.Content.Size, hres.BodySize = 0, 0
+			} else {
+				text, truncated, body, err := r.body(res.Body)
+				res.Body = body
+				if err != nil {
+					r.mu.Lock()
+					e.Error = err.Error()
+					e.Response = hres
+					r.mu.Unlock()
+					res.Body.Close()
+					return nil, err
+				}
+				hres.Content.Text, hres.Content.Truncated = text, truncated
+				res.Body = &countingBody{ReadCloser: res.Body, done: func(n int64) {
+					r.mu.Lock()
+					defer r.mu.Unlock()
+					e.Response.Content.Size, e.Response.BodySize = n, n
+				}}
+			}
+			r.mu.Lock()
+			e.Response = hres
+			r.mu.Unlock()
+			return res, nil
+		}
+	}
+}
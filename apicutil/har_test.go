@@ -0,0 +1,103 @@
+package apicutil_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/kolach/apic"
+	. "github.com/kolach/apic/apicutil"
+)
+
+var _ = Describe("WithHAR", func() {
+	var (
+		server *httptest.Server
+		har    *HARRecorder
+	)
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cr3t"})
+			fmt.Fprint(w, `{"token":"abc","id":1}`)
+		}))
+		har = NewHARRecorder(WithHARBodyLimit(14))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should record request and response with secrets redacted", func() {
+		req, _ := http.NewRequest("POST", server.URL+"/login?api_key=abc&page=2", strings.NewReader(`{"password":"qwerty"}`))
+		req.Header.Set("Authorization", "Bearer abc")
+		res, err := WithHAR(har)(http.DefaultClient.Do)(req)
+		Ω(err).ShouldNot(HaveOccurred())
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		Ω(string(b)).Should(Equal(`{"token":"abc","id":1}`))
+
+		log := har.Log()
+		Ω(log.Version).Should(Equal("1.2"))
+		Ω(log.Entries).Should(HaveLen(1))
+		e := log.Entries[0]
+		Ω(e.Request.URL).Should(HaveSuffix("/login?api_key=REDACTED&page=2"))
+		Ω(e.Request.QueryString).Should(Equal([]HARNameValue{{Name: "api_key", Value: "REDACTED"}, {Name: "page", Value: "2"}}))
+		Ω(e.Request.Headers).Should(ContainElement(HARNameValue{Name: "Authorization", Value: "REDACTED"}))
+		Ω(e.Request.PostData.Text).Should(Equal(`{"password":"REDACTED"`))
+		Ω(e.Request.PostData.Truncated).Should(BeTrue())
+		Ω(e.Response.Status).Should(Equal(http.StatusOK))
+		Ω(e.Response.Cookies).Should(Equal([]HARNameValue{{Name: "session", Value: "REDACTED"}}))
+		Ω(e.Response.Content.Size).Should(Equal(int64(22)))
+		Ω(e.Response.Content.Text).Should(Equal(`{"token":"REDACTED"`))
+		Ω(e.Response.Content.MimeType).Should(Equal("application/json"))
+		Ω(e.ServerIPAddress).Should(Equal("127.0.0.1"))
+		Ω(e.Time).Should(BeNumerically(">", 0))
+		Ω(e.Timings.Connect).Should(BeNumerically(">", 0))
+	})
+
+	It("should record every retry attempt with its error", func() {
+		do := func(req *http.Request) (*http.Response, error) {
+			return nil, fmt.Errorf("Error")
+		}
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		b := backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Millisecond), 1)
+		apic.WithRetry(b)(WithHAR(har)(do))(req)
+
+		entries := har.Log().Entries
+		Ω(entries).Should(HaveLen(2))
+		Ω(entries[0].Attempt).Should(Equal(1))
+		Ω(entries[1].Attempt).Should(Equal(2))
+		Ω(entries[1].Error).Should(Equal("Error"))
+	})
+
+	It("should write HAR document to file", func() {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		res, err := WithHAR(har)(http.DefaultClient.Do)(req)
+		Ω(err).ShouldNot(HaveOccurred())
+		res.Body.Close()
+
+		dir, err := ioutil.TempDir("", "har")
+		Ω(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "traffic.har")
+		Ω(har.WriteFile(path)).Should(Succeed())
+
+		b, _ := ioutil.ReadFile(path)
+		var doc map[string]map[string]interface{}
+		Ω(json.NewDecoder(bytes.NewReader(b)).Decode(&doc)).Should(Succeed())
+		Ω(doc["log"]["version"]).Should(Equal("1.2"))
+		Ω(doc["log"]["entries"]).Should(HaveLen(1))
+	})
+})
@@ -0,0 +1,327 @@
+package apicutil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kolach/apic"
+)
+
+// Redacted replaces sensitive values in log fields
+const Redacted = "REDACTED"
+
+// Logger is structured logger taking fields as alternating key-value pairs
+// the way log/slog does, so *slog.Logger can be used as is.
+type Logger interface {
+	Info(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// writerLogger writes entries in logfmt to writer
+type writerLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterLogger constructs Logger writing entries in logfmt form to w
+func NewWriterLogger(w io.Writer) Logger {
+	return &writerLogger{w: w}
+}
+
+// Info writes info entry
+func (l *writerLogger) Info(msg string, args ...interface{}) {
+	l.log("INFO", msg, args)
+}
+
+// Error writes error entry
+func (l *writerLogger) Error(msg string, args ...interface{}) {
+	l.log("ERROR", msg, args)
+}
+
+// log writes entry as single logfmt line
+func (l *writerLogger) log(level, msg string, args []interface{}) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "time=%s level=%s msg=%q", time.Now().Format(time.RFC3339Nano), level, msg)
+	for i := 0; i < len(args); i += 2 {
+		var v interface{} = "!MISSING"
+		if i+1 < len(args) {
+			v = args[i+1]
+		}
+		s := fmt.Sprint(v)
+		if strings.ContainsAny(s, " \t\n\"=") || s == "" {
+			s = fmt.Sprintf("%q", s)
+		}
+		fmt.Fprintf(&b, " %v=%s", args[i], s)
+	}
+	b.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.w, b.String())
+}
+
+// logConfig is logging interceptor configuration
+type logConfig struct {
+	headers       bool
+	bodyLimit     int
+	redactHeaders map[string]bool
+	redactQuery   map[string]bool
+	redactFields  *regexp.Regexp
+}
+
+// LogOptionFunc is functional type to configure logging interceptor
+type LogOptionFunc func(cfg *logConfig)
+
+// WithLogHeaders makes interceptor log request and response headers
+func WithLogHeaders() LogOptionFunc {
+	return func(cfg *logConfig) {
+		cfg.headers = true
+	}
+}
+
+// WithLogBodies makes interceptor log up to limit bytes of request and response bodies
+func WithLogBodies(limit int) LogOptionFunc {
+	return func(cfg *logConfig) {
+		cfg.bodyLimit = limit
+	}
+}
+
+// WithRedactHeaders adds headers to redact,
+// Authorization, Proxy-Authorization, Cookie, Set-Cookie and X-Api-Key are redacted by default
+func WithRedactHeaders(names ...string) LogOptionFunc {
+	return func(cfg *logConfig) {
+		for _, name := range names {
+			cfg.redactHeaders[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+}
+
+// WithRedactQuery adds query params to redact,
+// access_token, api_key, key, password, secret and token are redacted by default
+func WithRedactQuery(params ...string) LogOptionFunc {
+	return func(cfg *logConfig) {
+		for _, p := range params {
+			cfg.redactQuery[strings.ToLower(p)] = true
+		}
+	}
+}
+
+// WithRedactFields sets JSON body fields to redact,
+// default are access_token, password, refresh_token, secret and token
+func WithRedactFields(fields ...string) LogOptionFunc {
+	return func(cfg *logConfig) {
+		cfg.redactFields = fieldsPattern(fields)
+	}
+}
+
+// fieldsPattern matches values of given JSON fields, even in truncated body
+func fieldsPattern(fields []string) *regexp.Regexp {
+	if len(fields) == 0 {
+		return nil
+	}
+	quoted := make([]string, len(fields))
+	for i, f := range fields {
+		quoted[i] = regexp.QuoteMeta(f)
+	}
+	return regexp.MustCompile(`("(?:` + strings.Join(quoted, "|") + `)"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^\s,}\]]+)`)
+}
+
+// newLogConfig constructs logging configuration with default redaction lists
+func newLogConfig(opts []LogOptionFunc) *logConfig {
+	cfg := &logConfig{
+		redactHeaders: make(map[string]bool),
+		redactQuery:   make(map[string]bool),
+		redactFields:  fieldsPattern([]string{"access_token", "password", "refresh_token", "secret", "token"}),
+	}
+	WithRedactHeaders("Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key")(cfg)
+	WithRedactQuery("access_token", "api_key", "key", "password", "secret", "token")(cfg)
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// redactURL returns URL string with sensitive query params and password hidden
+func (cfg *logConfig) redactURL(u *url.URL) string {
+	r := *u
+	if r.User != nil {
+		if _, ok := r.User.Password(); ok {
+			r.User = url.UserPassword(r.User.Username(), Redacted)
+		}
+	}
+	q := r.Query()
+	redacted := false
+	for name, values := range q {
+		if cfg.redactQuery[strings.ToLower(name)] {
+			for i := range values {
+				values[i] = Redacted
+			}
+			redacted = true
+		}
+	}
+	if redacted {
+		r.RawQuery = q.Encode()
+	}
+	return r.String()
+}
+
+// redactHeader returns header copy with sensitive values hidden
+func (cfg *logConfig) redactHeader(h http.Header) map[string]string {
+	m := make(map[string]string, len(h))
+	for name, values := range h {
+		if cfg.redactHeaders[http.CanonicalHeaderKey(name)] {
+			m[name] = Redacted
+		} else {
+			m[name] = strings.Join(values, ", ")
+		}
+	}
+	return m
+}
+
+// formatHeader formats header map in stable order
+func formatHeader(m map[string]string) string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + ": " + m[name]
+	}
+	return strings.Join(parts, "; ")
+}
+
+// redactBody returns body snippet with sensitive JSON fields hidden
+func (cfg *logConfig) redactBody(b []byte, truncated bool) string {
+	s := string(b)
+	if cfg.redactFields != nil {
+		s = cfg.redactFields.ReplaceAllString(s, `${1}"`+Redacted+`"`)
+	}
+	if truncated {
+		s += "..."
+	}
+	return s
+}
+
+// peekBody reads up to limit bytes of body and returns body replacement
+// yielding the same bytes again
+func peekBody(body io.ReadCloser, limit int) ([]byte, bool, io.ReadCloser, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(body, int64(limit)+1))
+	if err != nil {
+		return nil, false, body, err
+	}
+	truncated := len(b) > limit
+	rest := struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(b), body), body}
+	if truncated {
+		b = b[:limit]
+	}
+	return b, truncated, rest, nil
+}
+
+// countingBody counts bytes read from body and reports them once it is closed
+type countingBody struct {
+	io.ReadCloser
+	n    int64
+	once sync.Once
+	done func(n int64)
+}
+
+// Read counts bytes read
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// Close closes body and reports byte count
+func (b *countingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.done(b.n) })
+	return err
+}
+
+// WithLogger logs requests with structured fields: method, URL with sensitive
+// query params redacted, status, duration till response headers, retry attempt,
+// request and response byte counts and error. Headers and bodies are logged when
+// configured, with sensitive headers and JSON fields redacted.
+// Entry of successful request is written when response body is closed,
+// so the response byte count is known.
+// Usage example:
+//
+// logger := slog.Default()
+// ...
+// res, err := c.Do(req, apicutil.WithLogger(logger, apicutil.WithLogBodies(1024)))
+//
+func WithLogger(l Logger, opts ...LogOptionFunc) apic.InterceptDoFunc {
+	cfg := newLogConfig(opts)
+
+	return func(do apic.DoFunc) apic.DoFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			fields := []interface{}{
+				"method", req.Method,
+				"url", cfg.redactURL(req.URL),
+			}
+			if attempt := apic.RetryAttempt(req.Context()); attempt > 0 {
+				fields = append(fields, "attempt", attempt)
+			}
+			if cfg.headers {
+				fields = append(fields, "request_headers", formatHeader(cfg.redactHeader(req.Header)))
+			}
+			if cfg.bodyLimit > 0 && req.Body != nil && req.Body != http.NoBody {
+				b, truncated, body, err := peekBody(req.Body, cfg.bodyLimit)
+				req.Body = body
+				if err != nil {
+					return nil, err
+				}
+				fields = append(fields, "request_body", cfg.redactBody(b, truncated))
+			}
+			if req.ContentLength > 0 {
+				fields = append(fields, "request_bytes", req.ContentLength)
+			}
+
+			start := time.Now()
+			res, err := do(req)
+			fields = append(fields, "duration", time.Since(start))
+			if err != nil {
+				l.Error("http request failed", append(fields, "error", err)...)
+				return res, err
+			}
+
+			fields = append(fields, "status", res.StatusCode)
+			if cfg.headers {
+				fields = append(fields, "response_headers", formatHeader(cfg.redactHeader(res.Header)))
+			}
+			if res.Body == nil {
+				l.Info("http request", fields...)
+				return res, nil
+			}
+			if cfg.bodyLimit > 0 {
+				b, truncated, body, err := peekBody(res.Body, cfg.bodyLimit)
+				res.Body = body
+				if err != nil {
+					res.Body.Close()
+					l.Error("http request failed", append(fields, "error", err)...)
+					return nil, err
+				}
+				fields = append(fields, "response_body", cfg.redactBody(b, truncated))
+			}
+			res.Body = &countingBody{ReadCloser: res.Body, done: func(n int64) {
+				l.Info("http request", append(fields, "response_bytes", n)...)
+			}}
+			return res, nil
+		}
+	}
+}
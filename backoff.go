@@ -2,9 +2,15 @@ package apic
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/cenkalti/backoff"
 	"github.com/pkg/errors"
@@ -30,23 +36,299 @@ func newSeekNopCloser(body io.ReadCloser) (io.ReadCloser, error) {
 	return &seekNopCloser{bytes.NewReader(b)}, nil
 }
 
+// isRetryable tells if request failed with error is worth to retry
+func isRetryable(err error) bool {
+	cause := errors.Cause(err)
+	if _, ok := cause.(*PanicError); ok {
+		return false
+	}
+	return cause != ErrCircuitOpen && cause != ErrBulkheadFull
+}
+
+// AttemptTimeoutError is returned when a single attempt exceeds per-attempt timeout,
+// as opposed to caller context deadline that bounds the whole retry loop.
+type AttemptTimeoutError struct {
+	Timeout time.Duration
+	Err     error
+}
+
+func (err *AttemptTimeoutError) Error() string {
+	return fmt.Sprintf("attempt timed out after %s: %v", err.Timeout, err.Err)
+}
+
+// retryConfig holds WithRetryNotify options
+type retryConfig struct {
+	attemptTimeout time.Duration  // per-attempt timeout, 0 if not bound
+	budget         *RetryBudget   // shared retry budget, nil if not limited
+	onEvent        RetryEventFunc // retry event callback
+	retryOn        ResponseFunc   // tells if response should be retried
+	exhausted      ExhaustedPolicy
+}
+
+// RetryOptionFunc is functional type to configure WithRetryNotify interceptor
+type RetryOptionFunc func(cfg *retryConfig)
+
+// WithAttemptTimeout bounds every single attempt with timeout.
+// Attempt timed out fails with *AttemptTimeoutError and is retried.
+// The timeout covers attempt till response is returned, reading response body
+// is bounded by caller context only.
+// Retry loop stopped by backoff bound to context with deadline closer than
+// the next retry delay returns the last *AttemptTimeoutError, as the deadline
+// itself is not exceeded yet.
+func WithAttemptTimeout(d time.Duration) RetryOptionFunc {
+	return func(cfg *retryConfig) {
+		cfg.attemptTimeout = d
+	}
+}
+
+// RetryEvent describes failed attempt which is going to be retried
+type RetryEvent struct {
+	Attempt    int           // number of failed attempt, starting from 1
+	Method     string        // request method
+	URL        *url.URL      // request URL
+	StatusCode int           // response status code, 0 if there was no response
+	Err        error         // attempt error
+	Delay      time.Duration // delay before next attempt
+	Elapsed    time.Duration // time elapsed since first attempt started
+}
+
+// RetryEventFunc is callback type to receive retry events
+type RetryEventFunc func(RetryEvent)
+
+// WithRetryEvent sets callback to be notified with RetryEvent before each retry
+func WithRetryEvent(fn RetryEventFunc) RetryOptionFunc {
+	return func(cfg *retryConfig) {
+		cfg.onEvent = fn
+	}
+}
+
+// ResponseFunc is predicate on response
+type ResponseFunc func(res *http.Response) bool
+
+// RetryOnStatus makes predicate matching responses with given status codes
+func RetryOnStatus(status ...int) ResponseFunc {
+	m := make(map[int]bool)
+	for _, s := range status {
+		m[s] = true
+	}
+	return func(res *http.Response) bool {
+		return m[res.StatusCode]
+	}
+}
+
+// RetryOnServerError is predicate matching 5xx and 429 Too Many Requests responses
+func RetryOnServerError(res *http.Response) bool {
+	return res.StatusCode >= http.StatusInternalServerError || res.StatusCode == http.StatusTooManyRequests
+}
+
+// ExhaustedPolicy tells what to return when retries of response are exhausted
+type ExhaustedPolicy int
+
+const (
+	// ReturnLastResponse returns the last response with no error
+	ReturnLastResponse ExhaustedPolicy = iota
+	// ReturnResponseError returns *ResponseError carrying the last response
+	ReturnResponseError
+)
+
+// ResponseError is retryable response error.
+// Response body is left unread, the caller is responsible to close it.
+type ResponseError struct {
+	Response *http.Response
+}
+
+func (err *ResponseError) Error() string {
+	return "retryable response: " + err.Response.Status
+}
+
+// WithRetryOnResponse makes retry interceptor retry responses matching predicate,
+// even if no error is returned by underlying do function.
+// Usage example:
+//
+// res, err := c.Do(req, WithRetry(b, WithRetryOnResponse(RetryOnStatus(http.StatusServiceUnavailable))))
+//
+func WithRetryOnResponse(fn ResponseFunc) RetryOptionFunc {
+	return func(cfg *retryConfig) {
+		cfg.retryOn = fn
+	}
+}
+
+// WithExhaustedPolicy sets what to return when retries of response are exhausted,
+// default is ReturnLastResponse.
+func WithExhaustedPolicy(p ExhaustedPolicy) RetryOptionFunc {
+	return func(cfg *retryConfig) {
+		cfg.exhausted = p
+	}
+}
+
+// statusCode extracts status code from attempt result
+func statusCode(res *http.Response, err error) int {
+	if res != nil {
+		return res.StatusCode
+	}
+	switch e := errors.Cause(err).(type) {
+	case *StatusError:
+		return e.StatusCode
+	case *ResponseError:
+		return e.Response.StatusCode
+	}
+	return 0
+}
+
+// WithRetryBudget makes retry interceptor consult shared budget before each retry.
+// Retries not allowed by the budget are suppressed and the last error is returned.
+func WithRetryBudget(b *RetryBudget) RetryOptionFunc {
+	return func(cfg *retryConfig) {
+		cfg.budget = b
+	}
+}
+
+// retry runs operation until it succeeds or backoff policy stops.
+// It works like backoff.RetryNotify, but consults retry budget before sleeping.
+func (cfg *retryConfig) retry(op backoff.Operation, b backoff.BackOff, n backoff.Notify) error {
+	ctx := context.Background()
+	if cb, ok := b.(backoff.BackOffContext); ok {
+		ctx = cb.Context()
+	}
+	if cfg.budget != nil {
+		cfg.budget.Request()
+	}
+
+	var t *time.Timer
+	b.Reset()
+	for {
+		err := op()
+		if err == nil {
+			return nil
+		}
+		if permanent, ok := err.(*backoff.PermanentError); ok {
+			return permanent.Err
+		}
+
+		next := b.NextBackOff()
+		if next == backoff.Stop || cfg.budget != nil && !cfg.budget.Withdraw() {
+			return err
+		}
+		if n != nil {
+			n(err, next)
+		}
+
+		if t == nil {
+			t = time.NewTimer(next)
+			defer t.Stop()
+		} else {
+			t.Reset(next)
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// retryStateKey is context key of retry loop state
+type retryStateKey struct{}
+
+// retryState is state of retry loop shared with interceptors run by its attempts
+type retryState struct {
+	mu      sync.Mutex
+	attempt int
+	tried   map[string]bool // endpoints tried by balancer
+}
+
+// RetryAttempt returns number of current retry loop attempt starting with 1,
+// or 0 if request is not run by retry interceptor
+func RetryAttempt(ctx context.Context) int {
+	state, ok := ctx.Value(retryStateKey{}).(*retryState)
+	if !ok {
+		return 0
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return state.attempt
+}
+
+// attemptContext is attempt context reporting DeadlineExceeded once attempt timeout fires
+type attemptContext struct {
+	context.Context
+	timedOut int32
+}
+
+// Err returns context.DeadlineExceeded if attempt timed out
+func (ctx *attemptContext) Err() error {
+	if atomic.LoadInt32(&ctx.timedOut) == 1 {
+		return context.DeadlineExceeded
+	}
+	return ctx.Context.Err()
+}
+
+// attempt performs a single request attempt under context derived from request one,
+// so caller context values and cancellation reach the attempt.
+// Attempt timeout is stopped once response is returned, so it doesn't bound body reading.
+func (cfg *retryConfig) attempt(do DoFunc, req *http.Request) (*http.Response, error) {
+	parent := req.Context()
+	cancelCtx, cancel := context.WithCancel(parent)
+	ctx := &attemptContext{Context: cancelCtx}
+	var timer *time.Timer
+	if cfg.attemptTimeout > 0 {
+		timer = time.AfterFunc(cfg.attemptTimeout, func() {
+			atomic.StoreInt32(&ctx.timedOut, 1)
+			cancel()
+		})
+	}
+
+	res, err := do(req.WithContext(ctx))
+	timedOut := timer != nil && !timer.Stop() && parent.Err() == nil
+	if err != nil {
+		cancel()
+		if timedOut {
+			return res, &AttemptTimeoutError{Timeout: cfg.attemptTimeout, Err: err}
+		}
+		return res, err
+	}
+	if timedOut {
+		// response came as attempt timed out, its body is not readable anymore
+		if res != nil && res.Body != nil {
+			res.Body.Close()
+		}
+		cancel()
+		return nil, &AttemptTimeoutError{Timeout: cfg.attemptTimeout, Err: context.DeadlineExceeded}
+	}
+	if res == nil || res.Body == nil {
+		cancel()
+		return res, nil
+	}
+	// keep attempt context alive till caller is done with the body
+	res.Body = &cancelOnClose{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+// discardResponse drains and closes response body
+func discardResponse(res *http.Response) {
+	if res.Body != nil {
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+	}
+}
+
 // WithRetry wraps http request executor function with provided backoff policy.
-func WithRetry(b backoff.BackOff) InterceptDoFunc {
-	return WithRetryNotify(func() backoff.BackOff { return b }, nil)
+func WithRetry(b backoff.BackOff, opts ...RetryOptionFunc) InterceptDoFunc {
+	return WithRetryNotify(func() backoff.BackOff { return b }, nil, opts...)
 }
 
 // WithRetryNotify wraps http request executor function with provided backoff policy
 // and report error on  each unsuccessful attempt.
-func WithRetryNotify(b NewBackOffFunc, n backoff.Notify) InterceptDoFunc {
+// If backoff is bound to context, the context bounds the whole retry loop and
+// its error is returned once the loop is stopped by it.
+func WithRetryNotify(b NewBackOffFunc, n backoff.Notify, opts ...RetryOptionFunc) InterceptDoFunc {
+	cfg := new(retryConfig)
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
 	return func(do DoFunc) DoFunc {
 		return func(req *http.Request) (res *http.Response, err error) {
-			// recover from panic in case we get it
-			defer func() {
-				if r := recover(); r != nil {
-					err = r.(error)
-				}
-			}()
-
 			// make request body seek-able for later re-use in retry attempts
 			if req.Body != nil {
 				if _, ok := req.Body.(io.Seeker); !ok {
@@ -56,22 +338,77 @@ func WithRetryNotify(b NewBackOffFunc, n backoff.Notify) InterceptDoFunc {
 				}
 			}
 
+			// share loop state with interceptors run by attempts
+			state := &retryState{tried: make(map[string]bool)}
+			req = req.WithContext(context.WithValue(req.Context(), retryStateKey{}, state))
+
 			// perform request,
 			// in case of failure, rewind request body to start to make it ready for a new request
+			start := time.Now()
+			attempt := 0
+			var retried *http.Response // response to be retried
 			op := func() (err error) {
-				if res, err = do(req); err != nil && req.Body != nil {
+				attempt++
+				state.mu.Lock()
+				state.attempt = attempt
+				state.mu.Unlock()
+				if retried != nil {
+					// we are going to retry, release previous response
+					discardResponse(retried)
+					retried = nil
+				}
+
+				res, err = cfg.attempt(do, req)
+				if err == nil && cfg.retryOn != nil && cfg.retryOn(res) {
+					retried = res
+					err = &ResponseError{Response: res}
+				}
+				if err != nil && req.Body != nil {
 					// restore request body in case of failure to re-use req object
 					seeker := req.Body.(io.Seeker)
-					if _, err := seeker.Seek(0, io.SeekStart); err != nil {
-						// never happened before, but just in case:
-						// we panic here to get out of backoff loop and recover in deffered function.
-						panic(errors.Wrap(err, "failed to seek to start"))
+					if _, seekErr := seeker.Seek(0, io.SeekStart); seekErr != nil {
+						// request can't be repeated, stop retrying
+						return backoff.Permanent(errors.Wrap(seekErr, "failed to seek to start"))
 					}
 				}
+				if err != nil && !isRetryable(err) {
+					return backoff.Permanent(err)
+				}
 				return
 			}
 
-			err = backoff.RetryNotify(op, b(), n)
+			notify := func(err error, next time.Duration) {
+				if n != nil {
+					n(err, next)
+				}
+				if cfg.onEvent != nil {
+					cfg.onEvent(RetryEvent{
+						Attempt:    attempt,
+						Method:     req.Method,
+						URL:        req.URL,
+						StatusCode: statusCode(res, err),
+						Err:        err,
+						Delay:      next,
+						Elapsed:    time.Since(start),
+					})
+				}
+			}
+
+			bo := b()
+			if err = cfg.retry(op, bo, notify); err != nil {
+				if cb, ok := bo.(backoff.BackOffContext); ok && cb.Context().Err() != nil {
+					if retried != nil {
+						discardResponse(retried)
+					}
+					return nil, errors.Wrapf(cb.Context().Err(), "retry stopped, last error: %v", err)
+				}
+				if retried != nil {
+					if cfg.exhausted == ReturnLastResponse {
+						return retried, nil
+					}
+					return nil, err
+				}
+			}
 			return
 		}
 	}
@@ -2,7 +2,9 @@ package apic_test
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"time"
@@ -10,6 +12,7 @@ import (
 	"github.com/cenkalti/backoff"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
 
 	. "github.com/kolach/apic"
 	. "github.com/kolach/gomega-matchers"
@@ -17,6 +20,11 @@ import (
 
 var errSeek = fmt.Errorf("failed to seek")
 
+// readerFunc is function implementing io.Reader
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
 type failOnSeek struct {
 	b *bytes.Buffer
 }
@@ -81,4 +89,181 @@ var _ = Describe("Retry", func() {
 			Ω(err).Should(BeCausedBy(errSeek))
 		})
 	})
+
+	Describe("WithAttemptTimeout", func() {
+		// hang blocks until request context is done
+		hang := func(req *http.Request) (*http.Response, error) {
+			count++
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		}
+
+		It("should bound every attempt with timeout and retry", func() {
+			req, _ := http.NewRequest("GET", "https://example.com", nil)
+			b := backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Millisecond), 2)
+			_, err := WithRetry(b, WithAttemptTimeout(10*time.Millisecond))(hang)(req)
+
+			Ω(count).Should(Equal(3))
+			timeoutErr, ok := errors.Cause(err).(*AttemptTimeoutError)
+			Ω(ok).Should(BeTrue())
+			Ω(timeoutErr.Timeout).Should(Equal(10 * time.Millisecond))
+			Ω(timeoutErr.Err).Should(Equal(context.DeadlineExceeded))
+		})
+
+		It("should keep attempt context alive till response body is closed", func() {
+			req, _ := http.NewRequest("GET", "https://example.com", nil)
+			var attemptCtx context.Context
+			res, err := WithRetry(b, WithAttemptTimeout(time.Second))(func(req *http.Request) (*http.Response, error) {
+				attemptCtx = req.Context()
+				return &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString("test"))}, nil
+			})(req)
+
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(attemptCtx.Err()).Should(BeNil())
+			res.Body.Close()
+			Ω(attemptCtx.Err()).Should(Equal(context.Canceled))
+		})
+
+		It("should not bound body reading with attempt timeout", func() {
+			req, _ := http.NewRequest("GET", "https://example.com", nil)
+			res, err := WithRetry(b, WithAttemptTimeout(20*time.Millisecond))(func(req *http.Request) (*http.Response, error) {
+				ctx := req.Context()
+				return &http.Response{Body: ioutil.NopCloser(readerFunc(func(p []byte) (int, error) {
+					// stream body slower than attempt timeout
+					time.Sleep(40 * time.Millisecond)
+					if err := ctx.Err(); err != nil {
+						return 0, err
+					}
+					return copy(p, "test"), io.EOF
+				}))}, nil
+			})(req)
+
+			Ω(err).ShouldNot(HaveOccurred())
+			body, err := ioutil.ReadAll(res.Body)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(body)).Should(Equal("test"))
+			res.Body.Close()
+		})
+
+		It("should report caller context error distinctly", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			req, _ := http.NewRequest("GET", "https://example.com", nil)
+			req = req.WithContext(ctx)
+			b := backoff.WithContext(backoff.NewConstantBackOff(time.Millisecond), ctx)
+			// first attempt times out, caller gives up during the second one
+			_, err := WithRetry(b, WithAttemptTimeout(10*time.Millisecond))(func(req *http.Request) (*http.Response, error) {
+				if count == 1 {
+					// second attempt signals caller to cancel
+					cancel()
+				}
+				return hang(req)
+			})(req)
+
+			Ω(count).Should(Equal(2))
+			Ω(errors.Cause(err)).Should(Equal(context.Canceled))
+		})
+
+		It("should return attempt timeout if caller deadline is closer than next retry", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			req, _ := http.NewRequest("GET", "https://example.com", nil)
+			b := backoff.WithContext(backoff.NewConstantBackOff(time.Hour), ctx)
+			_, err := WithRetry(b, WithAttemptTimeout(10*time.Millisecond))(hang)(req)
+
+			Ω(count).Should(Equal(1))
+			_, ok := errors.Cause(err).(*AttemptTimeoutError)
+			Ω(ok).Should(BeTrue())
+		})
+	})
+
+	Describe("WithRetryEvent", func() {
+		It("should report attempt metadata before each retry", func() {
+			var events []RetryEvent
+			var notified int
+			n := func(error, time.Duration) { notified++ }
+			req, _ := http.NewRequest("GET", "https://example.com/orders/1", nil)
+			b := func() backoff.BackOff {
+				return backoff.WithMaxRetries(backoff.NewConstantBackOff(10*time.Millisecond), 2)
+			}
+			do := WithExpectStatus(http.StatusOK)(func(req *http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusServiceUnavailable, Status: "Service Unavailable"}, nil
+			})
+			_, err := WithRetryNotify(b, n, WithRetryEvent(func(e RetryEvent) {
+				events = append(events, e)
+			}))(do)(req)
+
+			Ω(err).Should(MatchError("Service Unavailable"))
+			Ω(notified).Should(Equal(2))
+			Ω(events).Should(HaveLen(2))
+			for i, e := range events {
+				Ω(e.Attempt).Should(Equal(i + 1))
+				Ω(e.Method).Should(Equal("GET"))
+				Ω(e.URL.String()).Should(Equal("https://example.com/orders/1"))
+				Ω(e.StatusCode).Should(Equal(http.StatusServiceUnavailable))
+				Ω(e.Err).Should(MatchError("Service Unavailable"))
+				Ω(e.Delay).Should(Equal(10 * time.Millisecond))
+			}
+			Ω(events[1].Elapsed).Should(BeNumerically(">=", 10*time.Millisecond))
+		})
+	})
+
+	Describe("WithRetryOnResponse", func() {
+		var bodies []*closeTracker
+
+		// respondStatuses responds with given statuses one by one
+		respondStatuses := func(statuses ...int) DoFunc {
+			return func(req *http.Request) (*http.Response, error) {
+				status := statuses[count]
+				count++
+				body := &closeTracker{Reader: bytes.NewReader([]byte(http.StatusText(status)))}
+				bodies = append(bodies, body)
+				return &http.Response{StatusCode: status, Status: http.StatusText(status), Body: body}, nil
+			}
+		}
+
+		BeforeEach(func() {
+			bodies = nil
+		})
+
+		It("should retry matching responses till success", func() {
+			req, _ := http.NewRequest("GET", "https://example.com/orders/1", nil)
+			opt := WithRetryOnResponse(RetryOnStatus(http.StatusServiceUnavailable))
+			res, err := WithRetry(b, opt)(respondStatuses(503, 503, 200))(req)
+
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(res.StatusCode).Should(Equal(http.StatusOK))
+			Ω(count).Should(Equal(3))
+			Ω(bodies[0].isClosed()).Should(BeTrue())
+			Ω(bodies[1].isClosed()).Should(BeTrue())
+			Ω(bodies[2].isClosed()).Should(BeFalse())
+		})
+
+		It("should return the last response when retries are exhausted", func() {
+			req, _ := http.NewRequest("GET", "https://example.com/orders/1", nil)
+			b := backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Millisecond), 1)
+			res, err := WithRetry(b, WithRetryOnResponse(RetryOnServerError))(respondStatuses(503, 502))(req)
+
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(res.StatusCode).Should(Equal(http.StatusBadGateway))
+			body, _ := ioutil.ReadAll(res.Body)
+			Ω(string(body)).Should(Equal("Bad Gateway"))
+			Ω(bodies[1].isClosed()).Should(BeFalse())
+		})
+
+		It("should return error carrying the last response on request", func() {
+			req, _ := http.NewRequest("GET", "https://example.com/orders/1", nil)
+			b := backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Millisecond), 1)
+			res, err := WithRetry(b,
+				WithRetryOnResponse(RetryOnServerError),
+				WithExhaustedPolicy(ReturnResponseError),
+			)(respondStatuses(503, 429))(req)
+
+			Ω(res).Should(BeNil())
+			resErr, ok := err.(*ResponseError)
+			Ω(ok).Should(BeTrue())
+			Ω(resErr.Response.StatusCode).Should(Equal(http.StatusTooManyRequests))
+			Ω(err).Should(MatchError("retryable response: Too Many Requests"))
+		})
+	})
 })
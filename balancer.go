@@ -0,0 +1,420 @@
+package apic
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNoEndpoints is returned by balancer having no endpoints to pick from
+var ErrNoEndpoints = errors.New("no endpoints available")
+
+// EndpointsFunc returns base URLs of endpoints to balance requests among
+type EndpointsFunc func() ([]string, error)
+
+// StaticEndpoints returns fixed list of base URLs
+func StaticEndpoints(urls ...string) EndpointsFunc {
+	return func() ([]string, error) {
+		return urls, nil
+	}
+}
+
+// EndpointRankFunc returns priority and weight of endpoint the way SRV record does:
+// lower priority is preferred, weight is relative share among endpoints of the same priority
+type EndpointRankFunc func(u string) (priority, weight int)
+
+// BalanceStrategy is strategy of picking endpoint
+type BalanceStrategy int
+
+const (
+	// RoundRobin picks endpoints in turn
+	RoundRobin BalanceStrategy = iota
+	// RandomChoice picks random endpoint
+	RandomChoice
+	// LeastInFlight picks endpoint with fewest requests in flight
+	LeastInFlight
+	// LatencyWeighted picks random endpoint with probability inverse to its average latency
+	LatencyWeighted
+)
+
+// latencyDecay is weight of the latest sample in endpoint average latency
+const latencyDecay = 0.3
+
+// EndpointStatus is snapshot of endpoint state
+type EndpointStatus struct {
+	URL      string
+	Healthy  bool
+	InFlight int
+	Latency  time.Duration // moving average latency
+}
+
+// endpoint is state of a single endpoint
+type endpoint struct {
+	url       *url.URL
+	inFlight  int
+	latency   time.Duration
+	downUntil time.Time
+}
+
+// Balancer spreads requests among endpoints and keeps failing ones out for a cooldown
+type Balancer struct {
+	resolve  EndpointsFunc
+	strategy BalanceStrategy
+	cooldown time.Duration
+	failure  FailureFunc
+	rank     EndpointRankFunc
+
+	mu        sync.Mutex
+	endpoints map[string]*endpoint
+	next      int
+}
+
+// BalancerOptionFunc is functional type to configure balancer
+type BalancerOptionFunc func(b *Balancer)
+
+// WithBalanceStrategy sets strategy of picking endpoints, default is RoundRobin
+func WithBalanceStrategy(s BalanceStrategy) BalancerOptionFunc {
+	return func(b *Balancer) {
+		b.strategy = s
+	}
+}
+
+// WithEndpointCooldown sets how long failed endpoint is kept out, default is 10 seconds
+func WithEndpointCooldown(d time.Duration) BalancerOptionFunc {
+	return func(b *Balancer) {
+		b.cooldown = d
+	}
+}
+
+// WithEndpointFailure sets function deciding whether request outcome marks endpoint down
+func WithEndpointFailure(fn FailureFunc) BalancerOptionFunc {
+	return func(b *Balancer) {
+		b.failure = fn
+	}
+}
+
+// WithEndpointRank makes balancer pick only among endpoints of the best priority,
+// falling back to the next priority once they are all down or tried by retries.
+// RandomChoice picks endpoints with probability proportional to their weight.
+func WithEndpointRank(fn EndpointRankFunc) BalancerOptionFunc {
+	return func(b *Balancer) {
+		b.rank = fn
+	}
+}
+
+// NewBalancer constructs balancer among endpoints returned by resolve function.
+// The function is called on every pick, so it should be cheap or cache its result.
+func NewBalancer(resolve EndpointsFunc, opts ...BalancerOptionFunc) *Balancer {
+	b := &Balancer{
+		resolve:   resolve,
+		strategy:  RoundRobin,
+		cooldown:  10 * time.Second,
+		failure:   IsServerFailure,
+		endpoints: make(map[string]*endpoint),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// MarkDown keeps endpoint out for given duration
+func (b *Balancer) MarkDown(u string, d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e, ok := b.endpoints[u]; ok {
+		e.downUntil = time.Now().Add(d)
+	}
+}
+
+// MarkUp brings endpoint back
+func (b *Balancer) MarkUp(u string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e, ok := b.endpoints[u]; ok {
+		e.downUntil = time.Time{}
+	}
+}
+
+// Endpoints returns status of endpoints known to balancer
+func (b *Balancer) Endpoints() []EndpointStatus {
+	urls, err := b.resolve()
+	if err != nil {
+		return nil
+	}
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sync(urls)
+	status := make([]EndpointStatus, 0, len(urls))
+	for _, u := range urls {
+		if e, ok := b.endpoints[u]; ok {
+			status = append(status, EndpointStatus{
+				URL:      u,
+				Healthy:  !now.Before(e.downUntil),
+				InFlight: e.inFlight,
+				Latency:  e.latency,
+			})
+		}
+	}
+	return status
+}
+
+// sync adds newly resolved endpoints and forgets gone ones, must be called under lock
+func (b *Balancer) sync(urls []string) {
+	seen := make(map[string]bool, len(urls))
+	for _, u := range urls {
+		seen[u] = true
+		if _, ok := b.endpoints[u]; !ok {
+			parsed, err := url.Parse(u)
+			if err != nil {
+				continue
+			}
+			b.endpoints[u] = &endpoint{url: parsed}
+		}
+	}
+	for u := range b.endpoints {
+		if !seen[u] {
+			delete(b.endpoints, u)
+		}
+	}
+}
+
+// pick chooses endpoint skipping excluded ones. If every endpoint is down or excluded,
+// it falls back to all known endpoints rather than failing the request.
+func (b *Balancer) pick(exclude map[string]bool) (string, *endpoint, error) {
+	urls, err := b.resolve()
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to resolve endpoints")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sync(urls)
+
+	now := time.Now()
+	var candidates, up, all []string
+	for _, u := range urls {
+		e, ok := b.endpoints[u]
+		if !ok {
+			continue
+		}
+		all = append(all, u)
+		if now.Before(e.downUntil) {
+			continue
+		}
+		up = append(up, u)
+		if !exclude[u] {
+			candidates = append(candidates, u)
+		}
+	}
+	switch {
+	case len(candidates) > 0:
+	case len(up) > 0:
+		candidates = up
+	case len(all) > 0:
+		candidates = all
+	default:
+		return "", nil, ErrNoEndpoints
+	}
+	var weights []int
+	if b.rank != nil {
+		candidates, weights = b.best(candidates)
+	}
+
+	var u string
+	switch b.strategy {
+	case RandomChoice:
+		if weights != nil {
+			u = candidates[weightedIndex(weights)]
+		} else {
+			u = candidates[rand.Intn(len(candidates))]
+		}
+	case LeastInFlight:
+		u = candidates[0]
+		for _, c := range candidates[1:] {
+			if b.endpoints[c].inFlight < b.endpoints[u].inFlight {
+				u = c
+			}
+		}
+	case LatencyWeighted:
+		u = b.pickByLatency(candidates)
+	default:
+		// rotate over all endpoints, so skipped ones don't shift the turn of others
+		ok := make(map[string]bool, len(candidates))
+		for _, c := range candidates {
+			ok[c] = true
+		}
+		for i := 0; i < len(all); i++ {
+			if c := all[(b.next+i)%len(all)]; ok[c] {
+				u = c
+				b.next += i + 1
+				break
+			}
+		}
+	}
+	e := b.endpoints[u]
+	e.inFlight++
+	return u, e, nil
+}
+
+// best returns candidates of the best priority along with their weights
+func (b *Balancer) best(candidates []string) ([]string, []int) {
+	var best []string
+	var weights []int
+	bestPriority := 0
+	for _, c := range candidates {
+		priority, weight := b.rank(c)
+		if best != nil && priority > bestPriority {
+			continue
+		}
+		if best == nil || priority < bestPriority {
+			best, weights, bestPriority = nil, nil, priority
+		}
+		best = append(best, c)
+		weights = append(weights, weight)
+	}
+	return best, weights
+}
+
+// weightedIndex picks random index with probability proportional to its weight,
+// uniformly if all weights are zero
+func weightedIndex(weights []int) int {
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	if total <= 0 {
+		return rand.Intn(len(weights))
+	}
+	n := rand.Intn(total)
+	i := 0
+	for n >= weights[i] {
+		n -= weights[i]
+		i++
+	}
+	return i
+}
+
+// pickByLatency picks random endpoint weighted by inverse latency,
+// endpoints without samples get the weight of the fastest one
+func (b *Balancer) pickByLatency(candidates []string) string {
+	fastest := time.Duration(0)
+	for _, u := range candidates {
+		if l := b.endpoints[u].latency; l > 0 && (fastest == 0 || l < fastest) {
+			fastest = l
+		}
+	}
+	if fastest == 0 {
+		return candidates[rand.Intn(len(candidates))]
+	}
+	weights := make([]float64, len(candidates))
+	total := 0.0
+	for i, u := range candidates {
+		l := b.endpoints[u].latency
+		if l <= 0 {
+			l = fastest
+		}
+		weights[i] = 1 / float64(l)
+		total += weights[i]
+	}
+	r := rand.Float64() * total
+	for i, w := range weights {
+		if r -= w; r < 0 {
+			return candidates[i]
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+// done records request outcome of endpoint
+func (b *Balancer) done(e *endpoint, elapsed time.Duration, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e.inFlight--
+	if failed {
+		e.downUntil = time.Now().Add(b.cooldown)
+		return
+	}
+	if e.latency == 0 {
+		e.latency = elapsed
+	} else {
+		e.latency = time.Duration(latencyDecay*float64(elapsed) + (1-latencyDecay)*float64(e.latency))
+	}
+}
+
+// doneOnClose records endpoint outcome once response body is closed
+type doneOnClose struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+// Close closes body and records outcome
+func (r *doneOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.done)
+	return err
+}
+
+// WithBalancer sends request to endpoint picked by balancer, replacing
+// scheme and host of request URL and prefixing its path the way WithBaseURL does.
+// Used with retry interceptor, every retry goes to an endpoint not tried yet
+// while there are any left. Endpoints failing a request are kept out for a cooldown.
+// Usage example:
+//
+// b := NewBalancer(StaticEndpoints("https://a.example.com", "https://b.example.com"))
+// ...
+// res, err := client.Do(req, WithBalancer(b))
+//
+func WithBalancer(b *Balancer) InterceptDoFunc {
+	return func(do DoFunc) DoFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			var tried map[string]bool
+			state, _ := req.Context().Value(retryStateKey{}).(*retryState)
+			if state != nil {
+				state.mu.Lock()
+				tried = make(map[string]bool, len(state.tried))
+				for u := range state.tried {
+					tried[u] = true
+				}
+				state.mu.Unlock()
+			}
+
+			u, e, err := b.pick(tried)
+			if err != nil {
+				return nil, err
+			}
+			if state != nil {
+				state.mu.Lock()
+				state.tried[u] = true
+				state.mu.Unlock()
+			}
+
+			r := cloneRequest(req)
+			r.URL = new(url.URL)
+			*r.URL = *req.URL
+			r.URL.Scheme = e.url.Scheme
+			r.URL.Host = e.url.Host
+			r.URL.Path = e.url.Path + req.URL.Path
+			r.Host = ""
+
+			start := time.Now()
+			res, err := do(r)
+			failed := b.failure(res, err) && req.Context().Err() != context.Canceled
+			if err != nil || res == nil || res.Body == nil {
+				b.done(e, time.Since(start), failed)
+				return res, err
+			}
+			elapsed := time.Since(start)
+			res.Body = &doneOnClose{ReadCloser: res.Body, done: func() { b.done(e, elapsed, failed) }}
+			return res, nil
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package apic_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kolach/apic"
+)
+
+var _ = Describe("WithBalancer", func() {
+	var (
+		req   *http.Request
+		hosts []string
+		down  map[string]bool
+		do    DoFunc
+	)
+
+	BeforeEach(func() {
+		req, _ = http.NewRequest("GET", "/orders/1", nil)
+		hosts, down = nil, make(map[string]bool)
+		do = func(req *http.Request) (*http.Response, error) {
+			hosts = append(hosts, req.URL.Host)
+			status := http.StatusOK
+			if down[req.URL.Host] {
+				status = http.StatusServiceUnavailable
+			}
+			return &http.Response{
+				StatusCode: status,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(req.URL.String())),
+			}, nil
+		}
+	})
+
+	endpoints := StaticEndpoints("https://a.example.com/v1", "https://b.example.com/v1", "https://c.example.com/v1")
+
+	call := func(do DoFunc) *http.Response {
+		res, err := do(req)
+		Ω(err).ShouldNot(HaveOccurred())
+		res.Body.Close()
+		return res
+	}
+
+	It("should rewrite request URL with picked endpoint", func() {
+		res, err := WithBalancer(NewBalancer(endpoints))(do)(req)
+		Ω(err).ShouldNot(HaveOccurred())
+		b, _ := ioutil.ReadAll(res.Body)
+		Ω(string(b)).Should(Equal("https://a.example.com/v1/orders/1"))
+		Ω(req.URL.String()).Should(Equal("/orders/1"))
+	})
+
+	It("should pick endpoints in turn with round robin", func() {
+		b := NewBalancer(endpoints)
+		for i := 0; i < 4; i++ {
+			call(WithBalancer(b)(do))
+		}
+		Ω(hosts).Should(Equal([]string{"a.example.com", "b.example.com", "c.example.com", "a.example.com"}))
+	})
+
+	It("should keep failed endpoint out for cooldown", func() {
+		b := NewBalancer(endpoints, WithEndpointCooldown(time.Minute))
+		down["a.example.com"] = true
+		for i := 0; i < 4; i++ {
+			call(WithBalancer(b)(do))
+		}
+		Ω(hosts).Should(Equal([]string{"a.example.com", "b.example.com", "c.example.com", "b.example.com"}))
+
+		status := b.Endpoints()
+		Ω(status).Should(HaveLen(3))
+		Ω(status[0].Healthy).Should(BeFalse())
+		Ω(status[1].Healthy).Should(BeTrue())
+	})
+
+	It("should pick endpoint with fewest requests in flight", func() {
+		b := NewBalancer(endpoints, WithBalanceStrategy(LeastInFlight))
+		first, _ := WithBalancer(b)(do)(req)
+		second, _ := WithBalancer(b)(do)(req)
+		call(WithBalancer(b)(do))
+		first.Body.Close()
+		call(WithBalancer(b)(do))
+		second.Body.Close()
+		Ω(hosts).Should(Equal([]string{"a.example.com", "b.example.com", "c.example.com", "a.example.com"}))
+	})
+
+	It("should prefer faster endpoints with latency weighting", func() {
+		b := NewBalancer(StaticEndpoints("https://a.example.com", "https://b.example.com"),
+			WithBalanceStrategy(LatencyWeighted))
+		slow := func(req *http.Request) (*http.Response, error) {
+			if req.URL.Host == "b.example.com" {
+				time.Sleep(20 * time.Millisecond)
+			}
+			return do(req)
+		}
+		for i := 0; i < 40; i++ {
+			call(WithBalancer(b)(slow))
+		}
+		count := 0
+		for _, h := range hosts {
+			if h == "a.example.com" {
+				count++
+			}
+		}
+		Ω(count).Should(BeNumerically(">", 25))
+	})
+
+	It("should move to another endpoint on each retry", func() {
+		b := NewBalancer(endpoints, WithBalanceStrategy(RandomChoice))
+		down["a.example.com"], down["b.example.com"] = true, true
+		retry := WithRetry(backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Millisecond), 5),
+			WithRetryOnResponse(RetryOnServerError))
+
+		res, err := retry(WithBalancer(b)(do))(req)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(res.StatusCode).Should(Equal(http.StatusOK))
+		Ω(hosts).Should(HaveLen(len(unique(hosts))))
+		Ω(hosts[len(hosts)-1]).Should(Equal("c.example.com"))
+	})
+
+	It("should fail without endpoints", func() {
+		_, err := WithBalancer(NewBalancer(StaticEndpoints()))(do)(req)
+		Ω(err).Should(Equal(ErrNoEndpoints))
+	})
+})
+
+// unique returns distinct values
+func unique(values []string) []string {
+	seen := make(map[string]bool)
+	var res []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			res = append(res, v)
+		}
+	}
+	return res
+}
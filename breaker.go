@@ -0,0 +1,309 @@
+package apic
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrCircuitOpen is returned when circuit breaker rejects request.
+// Retry interceptor never retries it.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerState is circuit breaker state
+type BreakerState int
+
+// Circuit breaker states
+const (
+	StateClosed   BreakerState = iota // requests pass, failures are counted
+	StateOpen                         // requests are rejected with ErrCircuitOpen
+	StateHalfOpen                     // limited number of probe requests pass
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// KeyFunc maps request to a key used to scope per-key interceptor state
+type KeyFunc func(req *http.Request) string
+
+// HostKey scopes state per request host
+func HostKey(req *http.Request) string {
+	return req.URL.Host
+}
+
+// FailureFunc decides whether request outcome is a failure
+type FailureFunc func(res *http.Response, err error) bool
+
+// IsServerFailure treats transport errors and 5xx responses as failures
+func IsServerFailure(res *http.Response, err error) bool {
+	return err != nil || res == nil || res.StatusCode >= http.StatusInternalServerError
+}
+
+// StateChangeFunc is called on circuit breaker state transitions
+type StateChangeFunc func(key string, from, to BreakerState)
+
+// circuit is state of a single key
+type circuit struct {
+	state       BreakerState
+	generation  uint64    // incremented on every state change
+	changedAt   time.Time // when state was changed last time
+	requests    uint32    // requests counted in current window
+	failures    uint32    // failures counted in current window
+	consecutive uint32    // consecutive failures
+	inFlight    uint32    // probe requests in half-open state
+	successes   uint32    // successful probes in half-open state
+}
+
+// CircuitBreaker tracks failures per key and stops sending requests to
+// a failing downstream for a while.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	circuits  map[string]*circuit
+	key       KeyFunc
+	isFailure FailureFunc
+	onChange  StateChangeFunc
+
+	consecutive uint32        // consecutive failures to open circuit, 0 disables
+	ratio       float64       // failure ratio to open circuit, 0 disables
+	minRequests uint32        // min requests in window to apply failure ratio
+	interval    time.Duration // closed state counting window, 0 never clears
+	timeout     time.Duration // how long circuit stays open
+	probes      uint32        // max requests allowed in half-open state
+}
+
+// BreakerOptionFunc is functional type to configure circuit breaker
+type BreakerOptionFunc func(cb *CircuitBreaker)
+
+// WithConsecutiveFailures opens circuit after n consecutive failures, 0 disables the check
+func WithConsecutiveFailures(n uint32) BreakerOptionFunc {
+	return func(cb *CircuitBreaker) {
+		cb.consecutive = n
+	}
+}
+
+// WithFailureRatio opens circuit when failures to requests ratio reaches given value
+// provided there were at least minRequests in current window.
+func WithFailureRatio(ratio float64, minRequests uint32) BreakerOptionFunc {
+	return func(cb *CircuitBreaker) {
+		cb.ratio = ratio
+		cb.minRequests = minRequests
+	}
+}
+
+// WithBreakerInterval sets closed state window after which counters are cleared
+func WithBreakerInterval(d time.Duration) BreakerOptionFunc {
+	return func(cb *CircuitBreaker) {
+		cb.interval = d
+	}
+}
+
+// WithOpenTimeout sets how long circuit stays open before going half-open
+func WithOpenTimeout(d time.Duration) BreakerOptionFunc {
+	return func(cb *CircuitBreaker) {
+		cb.timeout = d
+	}
+}
+
+// WithHalfOpenProbes sets how many requests may pass in half-open state,
+// all of them must succeed to close the circuit.
+func WithHalfOpenProbes(n uint32) BreakerOptionFunc {
+	return func(cb *CircuitBreaker) {
+		cb.probes = n
+	}
+}
+
+// WithBreakerKey sets function to scope circuits, default is per host
+func WithBreakerKey(key KeyFunc) BreakerOptionFunc {
+	return func(cb *CircuitBreaker) {
+		cb.key = key
+	}
+}
+
+// WithBreakerFailure sets function to classify failures, default is IsServerFailure
+func WithBreakerFailure(fn FailureFunc) BreakerOptionFunc {
+	return func(cb *CircuitBreaker) {
+		cb.isFailure = fn
+	}
+}
+
+// WithStateChange sets callback to be notified on state transitions
+func WithStateChange(fn StateChangeFunc) BreakerOptionFunc {
+	return func(cb *CircuitBreaker) {
+		cb.onChange = fn
+	}
+}
+
+// NewCircuitBreaker constructs circuit breaker.
+// By default circuit opens after 5 consecutive failures and stays open for 30 seconds.
+func NewCircuitBreaker(opts ...BreakerOptionFunc) *CircuitBreaker {
+	cb := &CircuitBreaker{
+		circuits:    make(map[string]*circuit),
+		key:         HostKey,
+		isFailure:   IsServerFailure,
+		consecutive: 5,
+		interval:    time.Minute,
+		timeout:     30 * time.Second,
+		probes:      1,
+	}
+	for _, opt := range opts {
+		opt(cb)
+	}
+	if cb.probes == 0 {
+		cb.probes = 1
+	}
+	return cb
+}
+
+// State returns current state of circuit for given key
+func (cb *CircuitBreaker) State(key string) BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	c, ok := cb.circuits[key]
+	if !ok {
+		return StateClosed
+	}
+	if c.state == StateOpen && time.Since(c.changedAt) >= cb.timeout {
+		return StateHalfOpen
+	}
+	return c.state
+}
+
+// transition is a state change to report
+type transition struct {
+	key      string
+	from, to BreakerState
+}
+
+// notify reports state transition to callback if any
+func (cb *CircuitBreaker) notify(t *transition) {
+	if t != nil && cb.onChange != nil {
+		cb.onChange(t.key, t.from, t.to)
+	}
+}
+
+// setState changes circuit state and resets counters, must be called under lock
+func (cb *CircuitBreaker) setState(key string, c *circuit, state BreakerState, now time.Time) *transition {
+	t := &transition{key: key, from: c.state, to: state}
+	c.state = state
+	c.generation++
+	c.changedAt = now
+	c.requests, c.failures, c.consecutive = 0, 0, 0
+	c.inFlight, c.successes = 0, 0
+	return t
+}
+
+// allow checks if request can pass and returns circuit generation it belongs to
+func (cb *CircuitBreaker) allow(key string) (uint64, error) {
+	cb.mu.Lock()
+	var t *transition
+	defer func() {
+		cb.mu.Unlock()
+		cb.notify(t)
+	}()
+
+	now := time.Now()
+	c, ok := cb.circuits[key]
+	if !ok {
+		c = &circuit{changedAt: now}
+		cb.circuits[key] = c
+	}
+
+	switch c.state {
+	case StateClosed:
+		if cb.interval > 0 && now.Sub(c.changedAt) >= cb.interval {
+			c.changedAt = now
+			c.requests, c.failures, c.consecutive = 0, 0, 0
+		}
+	case StateOpen:
+		if now.Sub(c.changedAt) < cb.timeout {
+			return 0, ErrCircuitOpen
+		}
+		t = cb.setState(key, c, StateHalfOpen, now)
+		fallthrough
+	case StateHalfOpen:
+		if c.inFlight >= cb.probes {
+			return 0, ErrCircuitOpen
+		}
+		c.inFlight++
+	}
+	return c.generation, nil
+}
+
+// done records request outcome
+func (cb *CircuitBreaker) done(key string, generation uint64, failed bool) {
+	cb.mu.Lock()
+	var t *transition
+	defer func() {
+		cb.mu.Unlock()
+		cb.notify(t)
+	}()
+
+	c := cb.circuits[key]
+	if c.generation != generation {
+		// outcome of request started before last state change
+		return
+	}
+
+	now := time.Now()
+	switch c.state {
+	case StateClosed:
+		c.requests++
+		if !failed {
+			c.consecutive = 0
+			return
+		}
+		c.failures++
+		c.consecutive++
+		if cb.consecutive > 0 && c.consecutive >= cb.consecutive ||
+			cb.ratio > 0 && c.requests >= cb.minRequests && float64(c.failures)/float64(c.requests) >= cb.ratio {
+			t = cb.setState(key, c, StateOpen, now)
+		}
+	case StateHalfOpen:
+		c.inFlight--
+		if failed {
+			t = cb.setState(key, c, StateOpen, now)
+			return
+		}
+		if c.successes++; c.successes >= cb.probes {
+			t = cb.setState(key, c, StateClosed, now)
+		}
+	}
+}
+
+// WithCircuitBreaker rejects requests with ErrCircuitOpen while circuit
+// for request key is open.
+// Usage example:
+//
+// cb := NewCircuitBreaker(WithConsecutiveFailures(3), WithOpenTimeout(time.Minute))
+// ...
+// res, err := c.Do(req, WithCircuitBreaker(cb))
+//
+func WithCircuitBreaker(cb *CircuitBreaker) InterceptDoFunc {
+	return func(do DoFunc) DoFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			key := cb.key(req)
+			generation, err := cb.allow(key)
+			if err != nil {
+				return nil, errors.Wrapf(err, "request to %s rejected", key)
+			}
+			// count panic in inner interceptors as failure
+			failed := true
+			defer func() { cb.done(key, generation, failed) }()
+
+			res, err := do(req)
+			failed = cb.isFailure(res, err)
+			return res, err
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package apic_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+
+	. "github.com/kolach/apic"
+)
+
+// respondStatus returns do function responding with given status and counting calls
+func respondStatus(status int, count *int) DoFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		*count++
+		return &http.Response{StatusCode: status}, nil
+	}
+}
+
+var _ = Describe("CircuitBreaker", func() {
+	var (
+		req         *http.Request
+		count       int
+		transitions []string
+	)
+
+	onChange := func(key string, from, to BreakerState) {
+		transitions = append(transitions, fmt.Sprintf("%s: %s -> %s", key, from, to))
+	}
+
+	BeforeEach(func() {
+		count = 0
+		transitions = nil
+		req, _ = http.NewRequest("GET", "https://example.com/orders/1", nil)
+	})
+
+	It("should open after consecutive failures", func() {
+		cb := NewCircuitBreaker(WithConsecutiveFailures(3), WithStateChange(onChange))
+		do := WithCircuitBreaker(cb)(respondStatus(http.StatusServiceUnavailable, &count))
+
+		for i := 0; i < 3; i++ {
+			_, err := do(req)
+			Ω(err).ShouldNot(HaveOccurred())
+		}
+		Ω(cb.State("example.com")).Should(Equal(StateOpen))
+
+		_, err := do(req)
+		Ω(errors.Cause(err)).Should(Equal(ErrCircuitOpen))
+		Ω(count).Should(Equal(3))
+		Ω(transitions).Should(Equal([]string{"example.com: closed -> open"}))
+	})
+
+	It("should reset consecutive failures on success", func() {
+		cb := NewCircuitBreaker(WithConsecutiveFailures(2))
+		WithCircuitBreaker(cb)(respondStatus(http.StatusInternalServerError, &count))(req)
+		WithCircuitBreaker(cb)(respondStatus(http.StatusOK, &count))(req)
+		WithCircuitBreaker(cb)(respondStatus(http.StatusInternalServerError, &count))(req)
+		Ω(cb.State("example.com")).Should(Equal(StateClosed))
+	})
+
+	It("should open on failure ratio", func() {
+		cb := NewCircuitBreaker(WithConsecutiveFailures(0), WithFailureRatio(0.5, 4))
+		WithCircuitBreaker(cb)(respondStatus(http.StatusOK, &count))(req)
+		WithCircuitBreaker(cb)(respondStatus(http.StatusInternalServerError, &count))(req)
+		WithCircuitBreaker(cb)(respondStatus(http.StatusOK, &count))(req)
+		Ω(cb.State("example.com")).Should(Equal(StateClosed))
+		WithCircuitBreaker(cb)(respondStatus(http.StatusInternalServerError, &count))(req)
+		Ω(cb.State("example.com")).Should(Equal(StateOpen))
+	})
+
+	It("should close after successful probe in half-open state", func() {
+		cb := NewCircuitBreaker(
+			WithConsecutiveFailures(1),
+			WithOpenTimeout(10*time.Millisecond),
+			WithStateChange(onChange),
+		)
+		WithCircuitBreaker(cb)(respondStatus(http.StatusInternalServerError, &count))(req)
+		Ω(cb.State("example.com")).Should(Equal(StateOpen))
+
+		Eventually(func() BreakerState { return cb.State("example.com") }).Should(Equal(StateHalfOpen))
+		_, err := WithCircuitBreaker(cb)(respondStatus(http.StatusOK, &count))(req)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(cb.State("example.com")).Should(Equal(StateClosed))
+		Ω(transitions).Should(Equal([]string{
+			"example.com: closed -> open",
+			"example.com: open -> half-open",
+			"example.com: half-open -> closed",
+		}))
+	})
+
+	It("should re-open after failed probe in half-open state", func() {
+		cb := NewCircuitBreaker(WithConsecutiveFailures(1), WithOpenTimeout(10*time.Millisecond))
+		WithCircuitBreaker(cb)(respondStatus(http.StatusInternalServerError, &count))(req)
+		time.Sleep(15 * time.Millisecond)
+		WithCircuitBreaker(cb)(respondStatus(http.StatusInternalServerError, &count))(req)
+		Ω(cb.State("example.com")).Should(Equal(StateOpen))
+	})
+
+	It("should scope circuits by key", func() {
+		cb := NewCircuitBreaker(WithConsecutiveFailures(1))
+		WithCircuitBreaker(cb)(respondStatus(http.StatusInternalServerError, &count))(req)
+
+		other, _ := http.NewRequest("GET", "https://api.example.com/orders/1", nil)
+		_, err := WithCircuitBreaker(cb)(respondStatus(http.StatusOK, &count))(other)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(cb.State("example.com")).Should(Equal(StateOpen))
+		Ω(cb.State("api.example.com")).Should(Equal(StateClosed))
+	})
+
+	It("should not be retried", func() {
+		cb := NewCircuitBreaker(WithConsecutiveFailures(1))
+		b := backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Millisecond), 5)
+		req, _ := http.NewRequest("POST", "https://example.com/orders", bytes.NewBufferString("Buy iPhoneX"))
+		_, err := WithRetry(b)(WithCircuitBreaker(cb)(failWith(fmt.Errorf("Error"), &count)))(req)
+
+		Ω(errors.Cause(err)).Should(Equal(ErrCircuitOpen))
+		Ω(count).Should(Equal(1))
+	})
+})
@@ -0,0 +1,101 @@
+package apic
+
+import (
+	"sync"
+	"time"
+)
+
+// RetryBudgetStats is retry budget counters over current window
+type RetryBudgetStats struct {
+	Requests   int64 // requests in window
+	Retries    int64 // retries allowed in window
+	Suppressed int64 // retries suppressed since budget creation
+}
+
+// budgetSlot counts requests and retries of a single second
+type budgetSlot struct {
+	second   int64
+	requests int64
+	retries  int64
+}
+
+// RetryBudget limits retries to a ratio of requests over sliding window
+// to prevent retry storms. It is meant to be shared by all requests to
+// an upstream, see WithRetryBudget.
+type RetryBudget struct {
+	mu         sync.Mutex
+	ratio      float64
+	minRetries float64 // retries always allowed in window
+	slots      []budgetSlot
+	suppressed int64
+}
+
+// NewRetryBudget constructs retry budget allowing retries to be ratio of requests
+// in a given window plus minPerSecond retries per second regardless of requests count.
+// Usage example:
+//
+// budget := NewRetryBudget(0.2, 1, 10*time.Second)
+// c := NewClient(WithExponentialBackOff(), WithRetryOptions(WithRetryBudget(budget)))
+//
+func NewRetryBudget(ratio, minPerSecond float64, window time.Duration) *RetryBudget {
+	n := int((window + time.Second - 1) / time.Second)
+	if n < 1 {
+		n = 1
+	}
+	return &RetryBudget{
+		ratio:      ratio,
+		minRetries: minPerSecond * float64(n),
+		slots:      make([]budgetSlot, n),
+	}
+}
+
+// slot returns current second slot, must be called under lock
+func (b *RetryBudget) slot(now time.Time) *budgetSlot {
+	second := now.Unix()
+	s := &b.slots[second%int64(len(b.slots))]
+	if s.second != second {
+		*s = budgetSlot{second: second}
+	}
+	return s
+}
+
+// totals sums slots within window, must be called under lock
+func (b *RetryBudget) totals(now time.Time) (requests, retries int64) {
+	since := now.Unix() - int64(len(b.slots))
+	for _, s := range b.slots {
+		if s.second > since {
+			requests += s.requests
+			retries += s.retries
+		}
+	}
+	return
+}
+
+// Request records a request
+func (b *RetryBudget) Request() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.slot(time.Now()).requests++
+}
+
+// Withdraw tries to spend budget on a retry and tells if the retry is allowed
+func (b *RetryBudget) Withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	requests, retries := b.totals(now)
+	if float64(retries+1) > b.ratio*float64(requests)+b.minRetries {
+		b.suppressed++
+		return false
+	}
+	b.slot(now).retries++
+	return true
+}
+
+// Stats returns budget counters
+func (b *RetryBudget) Stats() RetryBudgetStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	requests, retries := b.totals(time.Now())
+	return RetryBudgetStats{Requests: requests, Retries: retries, Suppressed: b.suppressed}
+}
@@ -0,0 +1,50 @@
+package apic_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kolach/apic"
+)
+
+var _ = Describe("RetryBudget", func() {
+	It("should allow retries as ratio of requests", func() {
+		budget := NewRetryBudget(0.5, 0, 10*time.Second)
+		for i := 0; i < 4; i++ {
+			budget.Request()
+		}
+		Ω(budget.Withdraw()).Should(BeTrue())
+		Ω(budget.Withdraw()).Should(BeTrue())
+		Ω(budget.Withdraw()).Should(BeFalse())
+		Ω(budget.Stats()).Should(Equal(RetryBudgetStats{Requests: 4, Retries: 2, Suppressed: 1}))
+	})
+
+	It("should always allow minimum retries per second", func() {
+		budget := NewRetryBudget(0, 1, 3*time.Second)
+		Ω(budget.Withdraw()).Should(BeTrue())
+		Ω(budget.Withdraw()).Should(BeTrue())
+		Ω(budget.Withdraw()).Should(BeTrue())
+		Ω(budget.Withdraw()).Should(BeFalse())
+	})
+
+	It("should suppress retries of retry interceptor", func() {
+		var count int
+		budget := NewRetryBudget(0, 0.4, 5*time.Second)
+		b := backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Millisecond), 10)
+		req, _ := http.NewRequest("POST", "https://example.com", bytes.NewBufferString("Buy iPhoneX"))
+		_, err := WithRetry(b, WithRetryBudget(budget))(failWith(fmt.Errorf("Error"), &count))(req)
+
+		Ω(err).Should(MatchError("Error"))
+		Ω(count).Should(Equal(3))
+		stats := budget.Stats()
+		Ω(stats.Requests).Should(Equal(int64(1)))
+		Ω(stats.Retries).Should(Equal(int64(2)))
+		Ω(stats.Suppressed).Should(Equal(int64(1)))
+	})
+})
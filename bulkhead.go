@@ -0,0 +1,119 @@
+package apic
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// ErrBulkheadFull is returned when bulkhead has no free slots and its wait queue is full.
+// Retry interceptor never retries it.
+var ErrBulkheadFull = errors.New("bulkhead is full")
+
+// Bulkhead caps number of requests in flight to an upstream
+type Bulkhead struct {
+	slots    chan struct{}
+	maxQueue int64
+	inFlight int64
+	queued   int64
+}
+
+// NewBulkhead constructs bulkhead allowing maxConcurrent requests in flight
+// and up to maxQueue requests waiting for a free slot.
+func NewBulkhead(maxConcurrent, maxQueue int) *Bulkhead {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	return &Bulkhead{
+		slots:    make(chan struct{}, maxConcurrent),
+		maxQueue: int64(maxQueue),
+	}
+}
+
+// InFlight returns number of requests holding a slot
+func (b *Bulkhead) InFlight() int {
+	return int(atomic.LoadInt64(&b.inFlight))
+}
+
+// Queued returns number of requests waiting for a slot
+func (b *Bulkhead) Queued() int {
+	return int(atomic.LoadInt64(&b.queued))
+}
+
+// acquire takes a slot, waiting in queue if there is a room
+func (b *Bulkhead) acquire(ctx context.Context) error {
+	select {
+	case b.slots <- struct{}{}:
+		atomic.AddInt64(&b.inFlight, 1)
+		return nil
+	default:
+	}
+
+	if atomic.AddInt64(&b.queued, 1) > b.maxQueue {
+		atomic.AddInt64(&b.queued, -1)
+		return ErrBulkheadFull
+	}
+	defer atomic.AddInt64(&b.queued, -1)
+
+	select {
+	case b.slots <- struct{}{}:
+		atomic.AddInt64(&b.inFlight, 1)
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "bulkhead wait canceled")
+	}
+}
+
+// release frees a slot
+func (b *Bulkhead) release() {
+	atomic.AddInt64(&b.inFlight, -1)
+	<-b.slots
+}
+
+// releaseOnClose frees bulkhead slot once response body is closed
+type releaseOnClose struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+// Close closes body and releases the slot
+func (r *releaseOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
+
+// WithBulkhead limits number of concurrent requests with bulkhead.
+// A request holds its slot until response body is closed.
+// Usage example:
+//
+// b := NewBulkhead(10, 100)
+// ...
+// res, err := c.Do(req, WithBulkhead(b))
+//
+func WithBulkhead(b *Bulkhead) InterceptDoFunc {
+	return func(do DoFunc) DoFunc {
+		return func(req *http.Request) (res *http.Response, err error) {
+			if err = b.acquire(req.Context()); err != nil {
+				return nil, err
+			}
+
+			// release slot right away unless response body is handed over to caller
+			defer func() {
+				if res == nil || res.Body == nil {
+					b.release()
+				}
+			}()
+
+			if res, err = do(req); res != nil && res.Body != nil {
+				res.Body = &releaseOnClose{ReadCloser: res.Body, release: b.release}
+			}
+			return
+		}
+	}
+}
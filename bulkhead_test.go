@@ -0,0 +1,102 @@
+package apic_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+
+	. "github.com/kolach/apic"
+)
+
+// blockUntil returns do function responding once release channel is closed
+func blockUntil(release chan struct{}) DoFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		<-release
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(bytes.NewBufferString("test")),
+		}, nil
+	}
+}
+
+var _ = Describe("Bulkhead", func() {
+	var (
+		req     *http.Request
+		release chan struct{}
+	)
+
+	BeforeEach(func() {
+		req, _ = http.NewRequest("GET", "https://example.com/orders/1", nil)
+		release = make(chan struct{})
+	})
+
+	It("should fail fast when there are no free slots", func() {
+		b := NewBulkhead(1, 0)
+		do := WithBulkhead(b)(blockUntil(release))
+		go do(req)
+		Eventually(b.InFlight).Should(Equal(1))
+
+		_, err := do(req)
+		Ω(err).Should(Equal(ErrBulkheadFull))
+		close(release)
+	})
+
+	It("should queue requests when there is a room", func() {
+		b := NewBulkhead(1, 1)
+		do := WithBulkhead(b)(blockUntil(release))
+
+		results := make(chan error, 2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				res, err := do(req)
+				if err == nil {
+					res.Body.Close()
+				}
+				results <- err
+			}()
+		}
+		Eventually(b.Queued).Should(Equal(1))
+		Ω(b.InFlight()).Should(Equal(1))
+
+		_, err := do(req)
+		Ω(err).Should(Equal(ErrBulkheadFull))
+
+		close(release)
+		Ω(<-results).ShouldNot(HaveOccurred())
+		Ω(<-results).ShouldNot(HaveOccurred())
+		Eventually(b.InFlight).Should(BeZero())
+		Ω(b.Queued()).Should(BeZero())
+	})
+
+	It("should hold the slot until response body is closed", func() {
+		b := NewBulkhead(1, 0)
+		close(release)
+		res, err := WithBulkhead(b)(blockUntil(release))(req)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(b.InFlight()).Should(Equal(1))
+
+		res.Body.Close()
+		res.Body.Close()
+		Ω(b.InFlight()).Should(BeZero())
+	})
+
+	It("should stop waiting when context is canceled", func() {
+		b := NewBulkhead(1, 1)
+		do := WithBulkhead(b)(blockUntil(release))
+		go do(req)
+		Eventually(b.InFlight).Should(Equal(1))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := do(req.WithContext(ctx))
+		Ω(errors.Cause(err)).Should(Equal(context.DeadlineExceeded))
+		Ω(b.Queued()).Should(BeZero())
+		close(release)
+	})
+})
@@ -0,0 +1,490 @@
+package apic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheStatusHeader is response header WithCaching reports cache status in
+const CacheStatusHeader = "X-Cache-Status"
+
+// Cache statuses reported in CacheStatusHeader
+const (
+	CacheHit         = "HIT"         // fresh response served from cache
+	CacheMiss        = "MISS"        // response fetched from upstream
+	CacheRevalidated = "REVALIDATED" // cached response confirmed by upstream with 304
+	CacheStale       = "STALE"       // stale response served from cache
+)
+
+// DefaultCacheMaxBodySize is maximum body size of cached response unless configured with WithCacheMaxBodySize
+const DefaultCacheMaxBodySize = 10 << 20
+
+// cacheableStatus are statuses cacheable by default (RFC 9110, section 15.1)
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// cacheControl is parsed Cache-Control header
+type cacheControl map[string]string
+
+// parseCacheControl parses Cache-Control directives of header
+func parseCacheControl(h http.Header) cacheControl {
+	cc := make(cacheControl)
+	for _, line := range h[http.CanonicalHeaderKey("Cache-Control")] {
+		for _, part := range strings.Split(line, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, value := part, ""
+			if i := strings.IndexByte(part, '='); i >= 0 {
+				name, value = part[:i], strings.Trim(strings.TrimSpace(part[i+1:]), `"`)
+			}
+			cc[strings.ToLower(strings.TrimSpace(name))] = value
+		}
+	}
+	return cc
+}
+
+// has tells if directive is present
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// duration returns directive value in seconds as duration
+func (cc cacheControl) duration(directive string) (time.Duration, bool) {
+	v, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// cacheEntry is stored response
+type cacheEntry struct {
+	StatusCode   int
+	Status       string
+	Proto        string
+	ProtoMajor   int
+	ProtoMinor   int
+	Header       http.Header
+	Body         []byte
+	Vary         http.Header // request headers listed in response Vary
+	RequestTime  time.Time
+	ResponseTime time.Time
+}
+
+// date returns response Date or time it was received
+func (e *cacheEntry) date() time.Time {
+	if t, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		return t
+	}
+	return e.ResponseTime
+}
+
+// lifetime returns freshness lifetime of response (RFC 9111, section 4.2.1)
+func (e *cacheEntry) lifetime() time.Duration {
+	if d, ok := parseCacheControl(e.Header).duration("max-age"); ok {
+		return d
+	}
+	date := e.date()
+	if expires := e.Header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			// invalid Expires means already expired
+			return 0
+		}
+		return t.Sub(date)
+	}
+	// heuristic freshness
+	if t, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil && date.After(t) {
+		return date.Sub(t) / 10
+	}
+	return 0
+}
+
+// age returns current age of response (RFC 9111, section 4.2.3)
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	age := e.ResponseTime.Sub(e.date())
+	if n, err := strconv.ParseInt(e.Header.Get("Age"), 10, 64); err == nil && time.Duration(n)*time.Second > age {
+		age = time.Duration(n) * time.Second
+	}
+	if age < 0 {
+		age = 0
+	}
+	return age + now.Sub(e.ResponseTime)
+}
+
+// matches checks if request selects this entry according to Vary
+func (e *cacheEntry) matches(req *http.Request) bool {
+	for name, values := range e.Vary {
+		if strings.Join(req.Header[name], ",") != strings.Join(values, ",") {
+			return false
+		}
+	}
+	return true
+}
+
+// response constructs response from entry
+func (e *cacheEntry) response(req *http.Request, status string, now time.Time) *http.Response {
+	res := &http.Response{
+		StatusCode:    e.StatusCode,
+		Status:        e.Status,
+		Proto:         e.Proto,
+		ProtoMajor:    e.ProtoMajor,
+		ProtoMinor:    e.ProtoMinor,
+		Header:        e.Header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+	res.Header.Set("Age", strconv.FormatInt(int64(e.age(now)/time.Second), 10))
+	res.Header.Set(CacheStatusHeader, status)
+	return res
+}
+
+// staleWithin tells if stale entry can still be served given stale allowance directive
+func (e *cacheEntry) staleWithin(directive string, reqCC cacheControl, now time.Time) bool {
+	resCC := parseCacheControl(e.Header)
+	if resCC.has("must-revalidate") || resCC.has("no-cache") {
+		return false
+	}
+	d, ok := resCC.duration(directive)
+	if rd, rok := reqCC.duration(directive); rok {
+		d, ok = rd, true
+	}
+	return ok && e.age(now) <= e.lifetime()+d
+}
+
+// Cache is HTTP cache following RFC 9111 rules for a private cache
+type Cache struct {
+	store       CacheStore
+	maxBodySize int64
+
+	mu           sync.Mutex
+	revalidating map[string]bool // keys being revalidated in background
+}
+
+// CacheOptionFunc is functional type to configure cache
+type CacheOptionFunc func(c *Cache)
+
+// WithCacheMaxBodySize sets max body size of responses to store
+func WithCacheMaxBodySize(n int64) CacheOptionFunc {
+	return func(c *Cache) {
+		c.maxBodySize = n
+	}
+}
+
+// NewCache constructs cache backed by given store
+func NewCache(store CacheStore, opts ...CacheOptionFunc) *Cache {
+	c := &Cache{
+		store:        store,
+		maxBodySize:  DefaultCacheMaxBodySize,
+		revalidating: make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// cacheKey is store key of request
+func cacheKey(req *http.Request) string {
+	return "GET " + req.URL.String()
+}
+
+// load returns entry stored for request
+func (c *Cache) load(req *http.Request) *cacheEntry {
+	b, ok := c.store.Get(cacheKey(req))
+	if !ok {
+		return nil
+	}
+	e := new(cacheEntry)
+	if err := json.Unmarshal(b, e); err != nil || !e.matches(req) {
+		return nil
+	}
+	return e
+}
+
+// save stores entry for request
+func (c *Cache) save(req *http.Request, e *cacheEntry) {
+	if b, err := json.Marshal(e); err == nil {
+		c.store.Set(cacheKey(req), b)
+	}
+}
+
+// storable tells if response to request may be stored (RFC 9111, section 3)
+func storable(req *http.Request, res *http.Response) bool {
+	if !cacheableStatus[res.StatusCode] {
+		return false
+	}
+	if parseCacheControl(req.Header).has("no-store") {
+		return false
+	}
+	resCC := parseCacheControl(res.Header)
+	if resCC.has("no-store") || res.Header.Get("Vary") == "*" {
+		return false
+	}
+	// nothing to gain from response without freshness or validators
+	return resCC.has("max-age") || resCC.has("no-cache") || res.Header.Get("Expires") != "" ||
+		res.Header.Get("ETag") != "" || res.Header.Get("Last-Modified") != ""
+}
+
+// newCacheEntry reads response into entry. If body is larger than max body size,
+// nil entry is returned and response body is restored.
+func newCacheEntry(req *http.Request, res *http.Response, requestTime time.Time, maxBodySize int64) (*cacheEntry, error) {
+	var body []byte
+	if res.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(io.LimitReader(res.Body, maxBodySize+1))
+		if err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+		if int64(len(body)) > maxBodySize {
+			res.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), res.Body), res.Body}
+			return nil, nil
+		}
+		res.Body.Close()
+		res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	e := &cacheEntry{
+		StatusCode:   res.StatusCode,
+		Status:       res.Status,
+		Proto:        res.Proto,
+		ProtoMajor:   res.ProtoMajor,
+		ProtoMinor:   res.ProtoMinor,
+		Header:       res.Header.Clone(),
+		Body:         body,
+		Vary:         make(http.Header),
+		RequestTime:  requestTime,
+		ResponseTime: time.Now(),
+	}
+	for _, line := range res.Header[http.CanonicalHeaderKey("Vary")] {
+		for _, name := range strings.Split(line, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			e.Vary[name] = req.Header[name]
+		}
+	}
+	return e, nil
+}
+
+// fetch performs request and stores response if possible
+func (c *Cache) fetch(do DoFunc, req *http.Request) (*http.Response, error) {
+	requestTime := time.Now()
+	res, err := do(req)
+	if err != nil {
+		return nil, err
+	}
+	if storable(req, res) {
+		e, err := newCacheEntry(req, res, requestTime, c.maxBodySize)
+		if err != nil {
+			return nil, err
+		}
+		if e != nil {
+			c.save(req, e)
+		}
+	}
+	if res.Header == nil {
+		res.Header = make(http.Header)
+	}
+	res.Header.Set(CacheStatusHeader, CacheMiss)
+	return res, nil
+}
+
+// conditional makes conditional request to validate entry
+func conditional(req *http.Request, e *cacheEntry) *http.Request {
+	r := cloneRequest(req)
+	r.Header = req.Header.Clone()
+	if etag := e.Header.Get("ETag"); etag != "" {
+		r.Header.Set("If-None-Match", etag)
+	}
+	if lm := e.Header.Get("Last-Modified"); lm != "" {
+		r.Header.Set("If-Modified-Since", lm)
+	}
+	return r
+}
+
+// revalidate validates entry with upstream (RFC 9111, section 4.3)
+func (c *Cache) revalidate(do DoFunc, req *http.Request, e *cacheEntry) (*http.Response, error) {
+	requestTime := time.Now()
+	res, err := do(conditional(req, e))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusNotModified {
+		if storable(req, res) {
+			ne, err := newCacheEntry(req, res, requestTime, c.maxBodySize)
+			if err != nil {
+				return nil, err
+			}
+			if ne != nil {
+				c.save(req, ne)
+			}
+		} else if res.StatusCode < http.StatusInternalServerError {
+			c.store.Delete(cacheKey(req))
+		}
+		res.Header.Set(CacheStatusHeader, CacheMiss)
+		return res, nil
+	}
+
+	discardResponse(res)
+	for name, values := range res.Header {
+		if name != "Content-Length" {
+			e.Header[name] = values
+		}
+	}
+	e.RequestTime, e.ResponseTime = requestTime, time.Now()
+	c.save(req, e)
+	return e.response(req, CacheRevalidated, time.Now()), nil
+}
+
+// detachedContext keeps values of parent context, but not its deadline and cancellation
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)           { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}                 { return nil }
+func (detachedContext) Err() error                            { return nil }
+func (ctx detachedContext) Value(key interface{}) interface{} { return ctx.parent.Value(key) }
+
+// revalidateInBackground revalidates entry without blocking the caller
+func (c *Cache) revalidateInBackground(do DoFunc, req *http.Request, e *cacheEntry) {
+	key := cacheKey(req)
+	c.mu.Lock()
+	if c.revalidating[key] {
+		c.mu.Unlock()
+		return
+	}
+	c.revalidating[key] = true
+	c.mu.Unlock()
+
+	// caller may cancel its context as soon as it gets stale response,
+	// keep its values like route template and trace span though
+	r := req.WithContext(detachedContext{req.Context()})
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.revalidating, key)
+			c.mu.Unlock()
+		}()
+		if res, err := c.revalidate(do, r, e); err == nil {
+			discardResponse(res)
+		}
+	}()
+}
+
+// gatewayTimeout is response to only-if-cached request having no usable stored response
+func gatewayTimeout(req *http.Request) *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusGatewayTimeout,
+		Status:     "504 Gateway Timeout",
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{CacheStatusHeader: {CacheMiss}},
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
+
+// invalidates tells if request invalidates cached response of its URL
+func invalidates(req *http.Request, res *http.Response) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	return res.StatusCode < http.StatusBadRequest
+}
+
+// WithCaching serves GET requests from cache honoring Cache-Control, Expires and Vary,
+// revalidates stale responses with conditional requests and supports
+// stale-while-revalidate and stale-if-error directives.
+// Cache status is reported in CacheStatusHeader response header.
+// Usage example:
+//
+// cache := NewCache(NewMemoryCacheStore(64 << 20))
+// ...
+// res, err := c.Do(req, WithCaching(cache))
+//
+func WithCaching(c *Cache) InterceptDoFunc {
+	return func(do DoFunc) DoFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			if req.Method != http.MethodGet {
+				res, err := do(req)
+				if err == nil && invalidates(req, res) {
+					c.store.Delete(cacheKey(req))
+				}
+				return res, err
+			}
+
+			reqCC := parseCacheControl(req.Header)
+			e := c.load(req)
+			if e == nil {
+				if reqCC.has("only-if-cached") {
+					return gatewayTimeout(req), nil
+				}
+				return c.fetch(do, req)
+			}
+
+			now := time.Now()
+			age, lifetime := e.age(now), e.lifetime()
+			if maxAge, ok := reqCC.duration("max-age"); ok && maxAge < lifetime {
+				lifetime = maxAge
+			}
+			noCache := reqCC.has("no-cache") || req.Header.Get("Pragma") == "no-cache" ||
+				parseCacheControl(e.Header).has("no-cache")
+
+			if !noCache && age <= lifetime {
+				return e.response(req, CacheHit, now), nil
+			}
+			if reqCC.has("only-if-cached") {
+				// origin must not be contacted (RFC 9111 section 5.2.1.7),
+				// and stale or no-cache entry must not be served without validation
+				return gatewayTimeout(req), nil
+			}
+			if !noCache && e.staleWithin("stale-while-revalidate", reqCC, now) {
+				c.revalidateInBackground(do, req, e)
+				return e.response(req, CacheStale, now), nil
+			}
+
+			res, err := c.revalidate(do, req, e)
+			if (err != nil || res.StatusCode >= http.StatusInternalServerError) &&
+				e.staleWithin("stale-if-error", reqCC, time.Now()) {
+				if res != nil {
+					discardResponse(res)
+				}
+				return e.response(req, CacheStale, time.Now()), nil
+			}
+			return res, err
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package apic_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kolach/apic"
+)
+
+// origin is fake upstream server returning configured headers
+type origin struct {
+	calls  int32
+	status int
+	header http.Header
+	body   string
+	last   *http.Request
+	err    error
+}
+
+func (o *origin) do(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&o.calls, 1)
+	o.last = req
+	if o.err != nil {
+		return nil, o.err
+	}
+	status := o.status
+	if etag := o.header.Get("ETag"); etag != "" && req.Header.Get("If-None-Match") == etag {
+		status = http.StatusNotModified
+	}
+	header := o.header.Clone()
+	header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	return &http.Response{
+		StatusCode: status,
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Header:     header,
+		Body:       ioutil.NopCloser(bytes.NewBufferString(o.body)),
+	}, nil
+}
+
+func (o *origin) count() int32 {
+	return atomic.LoadInt32(&o.calls)
+}
+
+var _ = Describe("WithCaching", func() {
+	var (
+		o     *origin
+		cache *Cache
+		do    DoFunc
+	)
+
+	BeforeEach(func() {
+		o = &origin{status: http.StatusOK, header: make(http.Header), body: "test"}
+		cache = NewCache(NewMemoryCacheStore(1 << 20))
+		do = WithCaching(cache)(o.do)
+	})
+
+	get := func(header ...string) *http.Response {
+		req, _ := http.NewRequest("GET", "https://example.com/orders/1", nil)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		res, err := do(req)
+		Ω(err).ShouldNot(HaveOccurred())
+		return res
+	}
+
+	body := func(res *http.Response) string {
+		b, _ := ioutil.ReadAll(res.Body)
+		return string(b)
+	}
+
+	It("should serve fresh response from cache", func() {
+		o.header.Set("Cache-Control", "max-age=60")
+		Ω(get().Header.Get(CacheStatusHeader)).Should(Equal(CacheMiss))
+
+		res := get()
+		Ω(res.Header.Get(CacheStatusHeader)).Should(Equal(CacheHit))
+		Ω(res.Header.Get("Age")).Should(Equal("0"))
+		Ω(body(res)).Should(Equal("test"))
+		Ω(o.count()).Should(Equal(int32(1)))
+	})
+
+	It("should not store response with no-store", func() {
+		o.header.Set("Cache-Control", "no-store, max-age=60")
+		get()
+		Ω(get().Header.Get(CacheStatusHeader)).Should(Equal(CacheMiss))
+		Ω(o.count()).Should(Equal(int32(2)))
+	})
+
+	It("should revalidate stale response with ETag", func() {
+		o.header.Set("Cache-Control", "max-age=0")
+		o.header.Set("ETag", `"v1"`)
+		get()
+
+		res := get()
+		Ω(o.last.Header.Get("If-None-Match")).Should(Equal(`"v1"`))
+		Ω(res.StatusCode).Should(Equal(http.StatusOK))
+		Ω(res.Header.Get(CacheStatusHeader)).Should(Equal(CacheRevalidated))
+		Ω(body(res)).Should(Equal("test"))
+	})
+
+	It("should revalidate when request has no-cache", func() {
+		o.header.Set("Cache-Control", "max-age=60")
+		o.header.Set("ETag", `"v1"`)
+		get()
+		Ω(get("Cache-Control", "no-cache").Header.Get(CacheStatusHeader)).Should(Equal(CacheRevalidated))
+		Ω(o.count()).Should(Equal(int32(2)))
+	})
+
+	It("should answer only-if-cached miss with 504", func() {
+		res := get("Cache-Control", "only-if-cached")
+		Ω(res.StatusCode).Should(Equal(http.StatusGatewayTimeout))
+		Ω(o.count()).Should(Equal(int32(0)))
+	})
+
+	It("should answer only-if-cached with 504 for stale entry without contacting origin", func() {
+		o.header.Set("Cache-Control", "max-age=0")
+		get()
+		res := get("Cache-Control", "only-if-cached")
+		Ω(res.StatusCode).Should(Equal(http.StatusGatewayTimeout))
+		Consistently(o.count, 20*time.Millisecond).Should(Equal(int32(1)))
+	})
+
+	It("should answer only-if-cached with 504 for no-cache entry", func() {
+		o.header.Set("Cache-Control", "no-cache, max-age=60")
+		o.header.Set("ETag", `"v1"`)
+		get()
+		res := get("Cache-Control", "only-if-cached")
+		Ω(res.StatusCode).Should(Equal(http.StatusGatewayTimeout))
+		Ω(o.count()).Should(Equal(int32(1)))
+	})
+
+	It("should serve fresh entry to only-if-cached", func() {
+		o.header.Set("Cache-Control", "max-age=60")
+		get()
+		res := get("Cache-Control", "only-if-cached")
+		Ω(res.Header.Get(CacheStatusHeader)).Should(Equal(CacheHit))
+		Ω(o.count()).Should(Equal(int32(1)))
+	})
+
+	It("should select cached response by Vary headers", func() {
+		o.header.Set("Cache-Control", "max-age=60")
+		o.header.Set("Vary", "Accept")
+		get("Accept", "application/json")
+		Ω(get("Accept", "application/json").Header.Get(CacheStatusHeader)).Should(Equal(CacheHit))
+		Ω(get("Accept", "text/xml").Header.Get(CacheStatusHeader)).Should(Equal(CacheMiss))
+	})
+
+	It("should serve stale response on error with stale-if-error", func() {
+		o.header.Set("Cache-Control", "max-age=0, stale-if-error=60")
+		get()
+		o.err = fmt.Errorf("Error")
+		res := get()
+		Ω(res.Header.Get(CacheStatusHeader)).Should(Equal(CacheStale))
+		Ω(body(res)).Should(Equal("test"))
+	})
+
+	It("should serve stale response and revalidate in background with stale-while-revalidate", func() {
+		o.header.Set("Cache-Control", "max-age=0, stale-while-revalidate=60")
+		get()
+		Ω(get().Header.Get(CacheStatusHeader)).Should(Equal(CacheStale))
+		Eventually(o.count).Should(Equal(int32(2)))
+	})
+
+	It("should keep caller context values but not cancellation in background revalidation", func() {
+		type key struct{}
+		revalidated := make(chan context.Context, 1)
+		o := o // background revalidation may outlive the spec
+		do = WithCaching(cache)(func(req *http.Request) (*http.Response, error) {
+			if o.count() > 0 {
+				revalidated <- req.Context()
+			}
+			return o.do(req)
+		})
+		o.header.Set("Cache-Control", "max-age=0, stale-while-revalidate=60")
+		get()
+
+		ctx, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "value"))
+		req, _ := http.NewRequest("GET", "https://example.com/orders/1", nil)
+		res, err := do(req.WithContext(ctx))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(res.Header.Get(CacheStatusHeader)).Should(Equal(CacheStale))
+		cancel()
+
+		var revalidateCtx context.Context
+		Eventually(revalidated).Should(Receive(&revalidateCtx))
+		Ω(revalidateCtx.Value(key{})).Should(Equal("value"))
+		Ω(revalidateCtx.Err()).Should(BeNil())
+	})
+
+	It("should invalidate cached response on successful unsafe request", func() {
+		o.header.Set("Cache-Control", "max-age=60")
+		get()
+		req, _ := http.NewRequest("DELETE", "https://example.com/orders/1", nil)
+		_, err := do(req)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(get().Header.Get(CacheStatusHeader)).Should(Equal(CacheMiss))
+	})
+
+	It("should not store response larger than max body size", func() {
+		do = WithCaching(NewCache(NewMemoryCacheStore(1<<20), WithCacheMaxBodySize(2)))(o.do)
+		o.header.Set("Cache-Control", "max-age=60")
+		Ω(body(get())).Should(Equal("test"))
+		Ω(get().Header.Get(CacheStatusHeader)).Should(Equal(CacheMiss))
+	})
+})
+
+var _ = Describe("MemoryCacheStore", func() {
+	It("should evict least recently used values", func() {
+		s := NewMemoryCacheStore(4)
+		s.Set("a", []byte("12"))
+		s.Set("b", []byte("12"))
+		s.Get("a")
+		s.Set("c", []byte("12"))
+
+		_, ok := s.Get("b")
+		Ω(ok).Should(BeFalse())
+		_, ok = s.Get("a")
+		Ω(ok).Should(BeTrue())
+		Ω(s.Len()).Should(Equal(2))
+	})
+})
+
+var _ = Describe("DiskCacheStore", func() {
+	It("should store values in files", func() {
+		dir, err := ioutil.TempDir("", "apic")
+		Ω(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		s, err := NewDiskCacheStore(dir)
+		Ω(err).ShouldNot(HaveOccurred())
+		s.Set("GET https://example.com/", []byte("test"))
+		v, ok := s.Get("GET https://example.com/")
+		Ω(ok).Should(BeTrue())
+		Ω(string(v)).Should(Equal("test"))
+
+		s.Delete("GET https://example.com/")
+		_, ok = s.Get("GET https://example.com/")
+		Ω(ok).Should(BeFalse())
+	})
+})
@@ -0,0 +1,156 @@
+package apic
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// CacheStore is storage of cached responses used by Cache
+type CacheStore interface {
+	// Get returns value stored under key
+	Get(key string) ([]byte, bool)
+	// Set stores value under key
+	Set(key string, value []byte)
+	// Delete removes value stored under key
+	Delete(key string)
+}
+
+// lruItem is MemoryCacheStore list element value
+type lruItem struct {
+	key   string
+	value []byte
+}
+
+// MemoryCacheStore is in-memory CacheStore evicting least recently used values
+// once total size of values exceeds the limit.
+type MemoryCacheStore struct {
+	mu      sync.Mutex
+	items   map[string]*list.Element
+	lru     *list.List
+	size    int64
+	maxSize int64
+}
+
+// NewMemoryCacheStore constructs MemoryCacheStore keeping up to maxSize bytes of values
+func NewMemoryCacheStore(maxSize int64) *MemoryCacheStore {
+	return &MemoryCacheStore{
+		items:   make(map[string]*list.Element),
+		lru:     list.New(),
+		maxSize: maxSize,
+	}
+}
+
+// Get returns value stored under key
+func (s *MemoryCacheStore) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	s.lru.MoveToFront(e)
+	return e.Value.(*lruItem).value, true
+}
+
+// Set stores value under key
+func (s *MemoryCacheStore) Set(key string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if int64(len(value)) > s.maxSize {
+		s.remove(key)
+		return
+	}
+	if e, ok := s.items[key]; ok {
+		item := e.Value.(*lruItem)
+		s.size += int64(len(value) - len(item.value))
+		item.value = value
+		s.lru.MoveToFront(e)
+	} else {
+		s.items[key] = s.lru.PushFront(&lruItem{key: key, value: value})
+		s.size += int64(len(value))
+	}
+	for s.size > s.maxSize {
+		s.remove(s.lru.Back().Value.(*lruItem).key)
+	}
+}
+
+// Delete removes value stored under key
+func (s *MemoryCacheStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
+}
+
+// Len returns number of stored values
+func (s *MemoryCacheStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lru.Len()
+}
+
+// remove deletes value, must be called under lock
+func (s *MemoryCacheStore) remove(key string) {
+	if e, ok := s.items[key]; ok {
+		s.size -= int64(len(e.Value.(*lruItem).value))
+		s.lru.Remove(e)
+		delete(s.items, key)
+	}
+}
+
+// DiskCacheStore is CacheStore keeping values in files of a directory.
+// IO errors are treated as cache misses.
+type DiskCacheStore struct {
+	dir string
+}
+
+// NewDiskCacheStore constructs DiskCacheStore in given directory, creating it if needed
+func NewDiskCacheStore(dir string) (*DiskCacheStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &DiskCacheStore{dir: dir}, nil
+}
+
+// path returns file path of key
+func (s *DiskCacheStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:]))
+}
+
+// Get returns value stored under key
+func (s *DiskCacheStore) Get(key string) ([]byte, bool) {
+	b, err := ioutil.ReadFile(s.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+// Set stores value under key
+func (s *DiskCacheStore) Set(key string, value []byte) {
+	f, err := ioutil.TempFile(s.dir, "tmp-")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(value)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// rename is atomic, readers never see partially written value
+		err = os.Rename(f.Name(), s.path(key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+}
+
+// Delete removes value stored under key
+func (s *DiskCacheStore) Delete(key string) {
+	os.Remove(s.path(key))
+}
@@ -1,7 +1,6 @@
 package apic
 
 import (
-	"context"
 	"net/http"
 	"time"
 
@@ -16,32 +15,42 @@ type InterceptDoFunc func(DoFunc) DoFunc
 
 // Client for resin.io service
 type Client struct {
-	client     *http.Client   // HTTP api client to make requests
-	newBackOff NewBackOffFunc // new backoff factory function
-	notify     backoff.Notify // backoff error notify callback
+	client     *http.Client      // HTTP api client to make requests
+	newBackOff NewBackOffFunc    // new backoff factory function
+	notify     backoff.Notify    // backoff error notify callback
+	retryOpts  []RetryOptionFunc // retry interceptor options
+	recover    bool              // recover from panics in interceptors chain
+	fallback   *StaleFallback    // last known good responses served on final failure
+	tracer     Tracer            // tracer of calls and their attempts
 }
 
 // Do performs HTTP request to resin.io in a given context.
-// If the client is created with backoff option, the context is bound to generated backoff policy.
-// Otherwise the context gets bound to request object.
+// If the client is created with backoff option, the request context is also bound
+// to generated backoff policy, so it bounds the whole retry loop while every attempt
+// runs under a context derived from it, keeping its values and cancellation.
 func (c *Client) Do(req *http.Request, interceptors ...InterceptDoFunc) (*http.Response, error) {
 	if c.newBackOff != nil {
-		// extract context from request and set it to background
-		// original request context is going to be used in backoff
+		// If backoff factory function is provided, bind request context to backoff instance.
 		ctx := req.Context()
-		req = req.WithContext(context.Background())
-
-		// If backoff factory function is provided, bind context to backoff instance.
 		b := func() backoff.BackOff { return backoff.WithContext(c.newBackOff(), ctx) }
 		n := func(err error, d time.Duration) {
 			if c.notify != nil {
 				c.notify(err, d)
 			}
 		}
-		interceptors = append(interceptors, WithRetryNotify(b, n))
-	} else {
-		// Otherwise bind context to request object.
-		// req = req.WithContext(ctx)
+		interceptors = append(interceptors, WithRetryNotify(b, n, c.retryOpts...))
+	}
+	if c.fallback != nil {
+		interceptors = append(interceptors, WithStaleOnError(c.fallback))
+	}
+	if c.recover {
+		interceptors = append(interceptors, WithRecover())
+	}
+	if c.tracer != nil {
+		// attempt span is the innermost, so headers reach transport,
+		// and call span the outermost, covering retries and fallback
+		interceptors = append([]InterceptDoFunc{WithTracing(c.tracer)}, interceptors...)
+		interceptors = append(interceptors, withCallSpan(c.tracer))
 	}
 
 	// Uncomment to debug request/response
@@ -88,6 +97,63 @@ func WithConstantBackOff(d time.Duration) ClientOptionFunc {
 	})
 }
 
+// WithFullJitterBackOff configures backoff factory with full jitter:
+// random delay between 0 and exponentially growing interval capped by max.
+func WithFullJitterBackOff(initial, max time.Duration) ClientOptionFunc {
+	return WithBackOff(func() backoff.BackOff {
+		return NewFullJitterBackOff(initial, max)
+	})
+}
+
+// WithEqualJitterBackOff configures backoff factory with equal jitter:
+// half of exponentially growing interval plus random part of the other half.
+func WithEqualJitterBackOff(initial, max time.Duration) ClientOptionFunc {
+	return WithBackOff(func() backoff.BackOff {
+		return NewEqualJitterBackOff(initial, max)
+	})
+}
+
+// WithDecorrelatedJitterBackOff configures backoff factory with decorrelated jitter:
+// random delay between initial and 3 times previous delay capped by max.
+func WithDecorrelatedJitterBackOff(initial, max time.Duration) ClientOptionFunc {
+	return WithBackOff(func() backoff.BackOff {
+		return NewDecorrelatedJitterBackOff(initial, max)
+	})
+}
+
+// WithLinearBackOff configures backoff factory with delay growing by increment
+func WithLinearBackOff(initial, increment, max time.Duration) ClientOptionFunc {
+	return WithBackOff(func() backoff.BackOff {
+		return NewLinearBackOff(initial, increment, max)
+	})
+}
+
+// WithFibonacciBackOff configures backoff factory with delay growing as Fibonacci sequence
+func WithFibonacciBackOff(initial, max time.Duration) ClientOptionFunc {
+	return WithBackOff(func() backoff.BackOff {
+		return NewFibonacciBackOff(initial, max)
+	})
+}
+
+// WithScheduleBackOff configures backoff factory with fixed list of delays,
+// retries stop when the list is over.
+func WithScheduleBackOff(delays ...time.Duration) ClientOptionFunc {
+	return WithBackOff(func() backoff.BackOff {
+		return NewScheduleBackOff(delays...)
+	})
+}
+
+// WithMaxElapsedTime configures how long to keep retrying
+// make sure you setup some backoff factory function before.
+func WithMaxElapsedTime(d time.Duration) ClientOptionFunc {
+	return func(c *Client) {
+		b := c.newBackOff
+		c.newBackOff = func() backoff.BackOff {
+			return NewMaxElapsedTimeBackOff(b(), d)
+		}
+	}
+}
+
 // WithMaxRetries configures how many retries to make
 // make sure you setup bsome backoff factory function before.
 func WithMaxRetries(n uint64) ClientOptionFunc {
@@ -106,6 +172,48 @@ func WithNotify(n backoff.Notify) ClientOptionFunc {
 	}
 }
 
+// WithNotifyEvent allows to setup external notify callback receiving
+// detailed retry event
+func WithNotifyEvent(fn RetryEventFunc) ClientOptionFunc {
+	return WithRetryOptions(WithRetryEvent(fn))
+}
+
+// WithRetryOptions configures retry interceptor used with backoff.
+// Usage example:
+//
+//	c := NewClient(
+//		WithExponentialBackOff(),
+//		WithRetryOptions(WithAttemptTimeout(5*time.Second)),
+//	)
+func WithRetryOptions(opts ...RetryOptionFunc) ClientOptionFunc {
+	return func(c *Client) {
+		c.retryOpts = append(c.retryOpts, opts...)
+	}
+}
+
+// WithPanicRecovery makes client convert panics in interceptors chain into *PanicError
+func WithPanicRecovery() ClientOptionFunc {
+	return func(c *Client) {
+		c.recover = true
+	}
+}
+
+// WithStaleFallback makes client serve last known good response
+// when request fails after all retries
+func WithStaleFallback(f *StaleFallback) ClientOptionFunc {
+	return func(c *Client) {
+		c.fallback = f
+	}
+}
+
+// WithTracer makes client trace every call with span covering it
+// and child span per attempt propagated with W3C Trace Context headers
+func WithTracer(t Tracer) ClientOptionFunc {
+	return func(c *Client) {
+		c.tracer = t
+	}
+}
+
 // NewClient constructs a new resin.io client
 // all HTTP requests are done via provided
 func NewClient(opts ...ClientOptionFunc) *Client {
@@ -1,6 +1,8 @@
 package apic_test
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"time"
@@ -9,6 +11,7 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/ghttp"
+	"github.com/pkg/errors"
 )
 
 var _ = Describe("Client", func() {
@@ -110,5 +113,52 @@ var _ = Describe("Client", func() {
 				Ω(b).Should(Equal([]byte("test")))
 			})
 		})
+
+		Context("With backoff and caller context", func() {
+			type ctxKey string
+
+			var client *Client
+
+			BeforeEach(func() {
+				client = NewClient(
+					WithConstantBackOff(10*time.Millisecond),
+					WithMaxRetries(3),
+				)
+			})
+
+			It("should pass context values to every attempt", func() {
+				var traces []interface{}
+				intercept := func(do DoFunc) DoFunc {
+					return func(req *http.Request) (*http.Response, error) {
+						traces = append(traces, req.Context().Value(ctxKey("trace")))
+						return nil, fmt.Errorf("Error")
+					}
+				}
+
+				ctx := context.WithValue(context.Background(), ctxKey("trace"), "abc123")
+				req, _ := NewRequest("GET", "/api/orders/1", nil, WithContext(ctx))
+				_, err := client.Do(req, intercept)
+				Ω(err).Should(MatchError("Error"))
+				Ω(traces).Should(Equal([]interface{}{"abc123", "abc123", "abc123", "abc123"}))
+			})
+
+			It("should abort in-flight attempt when caller context is canceled", func() {
+				var count int
+				hang := func(do DoFunc) DoFunc {
+					return func(req *http.Request) (*http.Response, error) {
+						count++
+						<-req.Context().Done()
+						return nil, req.Context().Err()
+					}
+				}
+
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(10*time.Millisecond, cancel)
+				req, _ := NewRequest("GET", "/api/orders/1", nil, WithContext(ctx))
+				_, err := client.Do(req, hang)
+				Ω(errors.Cause(err)).Should(Equal(context.Canceled))
+				Ω(count).Should(Equal(1))
+			})
+		})
 	})
 })
@@ -0,0 +1,121 @@
+package apic
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// flight is upstream call shared by concurrent callers
+type flight struct {
+	done chan struct{}
+	res  *http.Response
+	body []byte
+	err  error
+}
+
+// response makes independent copy of shared response
+func (f *flight) response(req *http.Request) (*http.Response, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	res := new(http.Response)
+	*res = *f.res
+	res.Header = f.res.Header.Clone()
+	res.Trailer = f.res.Trailer.Clone()
+	res.Body = ioutil.NopCloser(bytes.NewReader(f.body))
+	res.Request = req
+	return res, nil
+}
+
+// Coalescer shares in-flight GET and HEAD requests among concurrent callers
+type Coalescer struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+	headers []string
+}
+
+// NewCoalescer constructs coalescer keying requests on method, URL and given headers.
+// Include every header affecting response, like Authorization or Accept.
+func NewCoalescer(headers ...string) *Coalescer {
+	return &Coalescer{flights: make(map[string]*flight), headers: headers}
+}
+
+// key builds coalescing key of request
+func (c *Coalescer) key(req *http.Request) string {
+	var b strings.Builder
+	b.WriteString(req.Method)
+	b.WriteByte(' ')
+	b.WriteString(req.URL.String())
+	for _, h := range c.headers {
+		b.WriteByte('\n')
+		b.WriteString(h)
+		b.WriteByte(':')
+		b.WriteString(strings.Join(req.Header[http.CanonicalHeaderKey(h)], ","))
+	}
+	return b.String()
+}
+
+// WithCoalescing shares one upstream call among concurrent identical GET and HEAD requests.
+// Every caller gets its own copy of response with body fully read into memory.
+// Cancellation of the caller which started the call fails it for everybody,
+// other callers just stop waiting when their context is done.
+// Usage example:
+//
+// c := NewCoalescer("Authorization")
+// ...
+// res, err := client.Do(req, WithCoalescing(c))
+//
+func WithCoalescing(c *Coalescer) InterceptDoFunc {
+	return func(do DoFunc) DoFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			if req.Method != http.MethodGet && req.Method != http.MethodHead {
+				return do(req)
+			}
+
+			key := c.key(req)
+			c.mu.Lock()
+			if f, ok := c.flights[key]; ok {
+				c.mu.Unlock()
+				select {
+				case <-f.done:
+					return f.response(req)
+				case <-req.Context().Done():
+					return nil, errors.Wrap(req.Context().Err(), "coalesced request canceled")
+				}
+			}
+			f := &flight{done: make(chan struct{})}
+			c.flights[key] = f
+			c.mu.Unlock()
+
+			defer func() {
+				c.mu.Lock()
+				delete(c.flights, key)
+				c.mu.Unlock()
+				close(f.done)
+			}()
+
+			// panics must not leave waiters with empty flight
+			f.err = errors.New("coalesced request failed")
+			res, err := do(req)
+			if err != nil {
+				f.err = err
+				return nil, err
+			}
+			if res.Body != nil {
+				f.body, err = ioutil.ReadAll(res.Body)
+				res.Body.Close()
+				if err != nil {
+					f.err = errors.Wrap(err, "failed to read response body")
+					return nil, f.err
+				}
+			}
+			f.res, f.err = res, nil
+			return f.response(req)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package apic_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kolach/apic"
+)
+
+var _ = Describe("WithCoalescing", func() {
+	var (
+		calls   int32
+		release chan struct{}
+		do      DoFunc
+	)
+
+	BeforeEach(func() {
+		calls = 0
+		release = make(chan struct{})
+		do = func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			res := &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": {"text/plain"}},
+				Body:       ioutil.NopCloser(bytes.NewBufferString("test")),
+			}
+			return res, nil
+		}
+	})
+
+	// fire runs n requests concurrently and collects responses
+	fire := func(do DoFunc, reqs ...*http.Request) []*http.Response {
+		responses := make([]*http.Response, len(reqs))
+		var wg sync.WaitGroup
+		for i, req := range reqs {
+			wg.Add(1)
+			go func(i int, req *http.Request) {
+				defer wg.Done()
+				responses[i], _ = do(req)
+			}(i, req)
+		}
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		return responses
+	}
+
+	newRequest := func(method, token string) *http.Request {
+		req, _ := http.NewRequest(method, "https://example.com/orders/1", nil)
+		req.Header.Set("Authorization", token)
+		return req
+	}
+
+	It("should share one upstream call among identical requests", func() {
+		c := NewCoalescer("Authorization")
+		responses := fire(WithCoalescing(c)(do),
+			newRequest("GET", "a"), newRequest("GET", "a"), newRequest("GET", "a"))
+
+		Ω(atomic.LoadInt32(&calls)).Should(Equal(int32(1)))
+		for _, res := range responses {
+			Ω(res.StatusCode).Should(Equal(http.StatusOK))
+			b, _ := ioutil.ReadAll(res.Body)
+			Ω(string(b)).Should(Equal("test"))
+		}
+		responses[0].Header.Set("Content-Type", "changed")
+		Ω(responses[1].Header.Get("Content-Type")).Should(Equal("text/plain"))
+	})
+
+	It("should not share calls with different key headers", func() {
+		c := NewCoalescer("Authorization")
+		fire(WithCoalescing(c)(do), newRequest("GET", "a"), newRequest("GET", "b"))
+		Ω(atomic.LoadInt32(&calls)).Should(Equal(int32(2)))
+	})
+
+	It("should not coalesce non GET requests", func() {
+		c := NewCoalescer()
+		fire(WithCoalescing(c)(do), newRequest("POST", "a"), newRequest("POST", "a"))
+		Ω(atomic.LoadInt32(&calls)).Should(Equal(int32(2)))
+	})
+})
@@ -0,0 +1,324 @@
+package apic
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// DefaultDecompressLimit is maximum size of decompressed response body
+// unless configured with WithDecompressLimit.
+const DefaultDecompressLimit = 64 << 20
+
+// ErrBodyTooLarge is returned on reading decompressed body exceeding the size limit
+var ErrBodyTooLarge = errors.New("decompressed body exceeds size limit")
+
+// Decoder constructs reader decoding content-coded stream
+type Decoder func(io.Reader) (io.ReadCloser, error)
+
+// Encoder constructs writer encoding stream with content-coding
+type Encoder func(io.Writer) (io.WriteCloser, error)
+
+// GzipDecoder decodes gzip content-coding
+func GzipDecoder(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// DeflateDecoder decodes deflate content-coding.
+// Both zlib wrapped (RFC 1950) and raw (RFC 1951) streams are accepted,
+// since servers disagree on what deflate means.
+func DeflateDecoder(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// BrotliDecoder decodes br content-coding
+func BrotliDecoder(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(brotli.NewReader(r)), nil
+}
+
+// ZstdDecoder decodes zstd content-coding
+func ZstdDecoder(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
+
+// GzipEncoder encodes with gzip content-coding
+func GzipEncoder(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+// DeflateEncoder encodes with deflate (zlib wrapped) content-coding
+func DeflateEncoder(w io.Writer) (io.WriteCloser, error) {
+	return zlib.NewWriter(w), nil
+}
+
+// BrotliEncoder encodes with br content-coding
+func BrotliEncoder(w io.Writer) (io.WriteCloser, error) {
+	return brotli.NewWriter(w), nil
+}
+
+// ZstdEncoder encodes with zstd content-coding
+func ZstdEncoder(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w)
+}
+
+type decompressor struct {
+	encodings []string           // encodings in order of preference
+	decoders  map[string]Decoder // decoders by encoding name
+	limit     int64              // max decompressed body size
+}
+
+// DecompressOptionFunc is functional type to configure WithDecompress interceptor
+type DecompressOptionFunc func(d *decompressor)
+
+// WithDecoder registers decoder for given content-coding.
+// Use it to add codings not supported out of the box or replace default decoders:
+//
+// WithDecompress(WithDecoder("lz4", func(r io.Reader) (io.ReadCloser, error) {
+// 	return ioutil.NopCloser(lz4.NewReader(r)), nil
+// }))
+//
+func WithDecoder(encoding string, dec Decoder) DecompressOptionFunc {
+	return func(d *decompressor) {
+		encoding = strings.ToLower(encoding)
+		if _, ok := d.decoders[encoding]; !ok {
+			d.encodings = append(d.encodings, encoding)
+		}
+		d.decoders[encoding] = dec
+	}
+}
+
+// WithDecompressLimit sets maximum size of decompressed body, n <= 0 disables the limit
+func WithDecompressLimit(n int64) DecompressOptionFunc {
+	return func(d *decompressor) {
+		d.limit = n
+	}
+}
+
+// WithDecompress advertises supported encodings with Accept-Encoding header
+// and transparently decodes response body. gzip, deflate, br and zstd are
+// supported by default, other codings can be added with WithDecoder.
+// Responses without body (HEAD, 204, 304 or empty) are left as is.
+// Reading more than the decompress limit fails with ErrBodyTooLarge.
+// Usage example:
+//
+// res, err := c.Do(req, WithDecompress(WithDecompressLimit(10 << 20)))
+//
+func WithDecompress(opts ...DecompressOptionFunc) InterceptDoFunc {
+	d := &decompressor{decoders: make(map[string]Decoder), limit: DefaultDecompressLimit}
+	WithDecoder("gzip", GzipDecoder)(d)
+	WithDecoder("deflate", DeflateDecoder)(d)
+	WithDecoder("br", BrotliDecoder)(d)
+	WithDecoder("zstd", ZstdDecoder)(d)
+	for _, opt := range opts {
+		opt(d)
+	}
+	acceptEncoding := strings.Join(d.encodings, ", ")
+
+	return func(do DoFunc) DoFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get("Accept-Encoding") == "" {
+				r := cloneRequest(req)
+				r.Header = req.Header.Clone()
+				if r.Header == nil {
+					r.Header = make(http.Header)
+				}
+				r.Header.Set("Accept-Encoding", acceptEncoding)
+				req = r
+			}
+
+			res, err := do(req)
+			if err != nil || res == nil || res.Body == nil {
+				return res, err
+			}
+			if err = d.decode(req, res); err != nil {
+				res.Body.Close()
+				return nil, err
+			}
+			return res, nil
+		}
+	}
+}
+
+// decode replaces response body with decoding reader
+func (d *decompressor) decode(req *http.Request, res *http.Response) error {
+	if !hasBody(req, res) {
+		return nil
+	}
+	var encodings []string
+	for _, e := range strings.Split(res.Header.Get("Content-Encoding"), ",") {
+		if e = strings.ToLower(strings.TrimSpace(e)); e != "" && e != "identity" {
+			encodings = append(encodings, e)
+		}
+	}
+	if len(encodings) == 0 {
+		return nil
+	}
+	for _, e := range encodings {
+		if _, ok := d.decoders[e]; !ok {
+			// leave body as is, caller has to deal with unknown coding
+			return nil
+		}
+	}
+
+	body := &decodedBody{closers: []io.Closer{res.Body}}
+	var r io.Reader = res.Body
+	// codings are listed in order they were applied, so decode in reverse
+	for i := len(encodings) - 1; i >= 0; i-- {
+		rc, err := d.decoders[encodings[i]](r)
+		if err == io.EOF {
+			// empty body, e.g. chunked response without data, nothing to decode
+			rc, err = http.NoBody, nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to decode %s response body", encodings[i])
+		}
+		body.closers = append(body.closers, rc)
+		r = rc
+	}
+	body.r = r
+	body.limited = d.limit > 0
+	body.remaining = d.limit
+
+	res.Body = body
+	res.Header.Del("Content-Encoding")
+	res.Header.Del("Content-Length")
+	res.ContentLength = -1
+	res.Uncompressed = true
+	return nil
+}
+
+// hasBody reports whether response to req may carry a body to decode
+func hasBody(req *http.Request, res *http.Response) bool {
+	if req.Method == http.MethodHead || res.ContentLength == 0 {
+		return false
+	}
+	return res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusNotModified
+}
+
+// decodedBody reads decoded stream enforcing size limit
+type decodedBody struct {
+	r         io.Reader
+	closers   []io.Closer
+	limited   bool  // true if size limit is set
+	remaining int64 // bytes left till limit
+}
+
+// Read reads decoded data and fails with ErrBodyTooLarge when limit is exceeded
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if !b.limited {
+		return b.r.Read(p)
+	}
+	if b.remaining <= 0 {
+		// make sure there is really more data before failing
+		var one [1]byte
+		if n, _ := io.ReadFull(b.r, one[:]); n == 0 {
+			return 0, io.EOF
+		}
+		return 0, ErrBodyTooLarge
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.r.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// Close closes decoders and the original body
+func (b *decodedBody) Close() (err error) {
+	for i := len(b.closers) - 1; i >= 0; i-- {
+		if e := b.closers[i].Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// WithCompressRequest encodes request body with given content-coding
+// if the body is at least minSize bytes long and sets Content-Encoding header.
+// Supported encodings are gzip, deflate, br and zstd.
+// Usage example:
+//
+// res, err := c.Do(req, WithCompressRequest("gzip", 1024))
+//
+func WithCompressRequest(encoding string, minSize int64) InterceptDoFunc {
+	encoders := map[string]Encoder{
+		"gzip":    GzipEncoder,
+		"deflate": DeflateEncoder,
+		"br":      BrotliEncoder,
+		"zstd":    ZstdEncoder,
+	}
+	return WithCompressRequestEncoder(encoding, encoders[strings.ToLower(encoding)], minSize)
+}
+
+// WithCompressRequestEncoder is like WithCompressRequest, but with custom encoder
+func WithCompressRequestEncoder(encoding string, enc Encoder, minSize int64) InterceptDoFunc {
+	return func(do DoFunc) DoFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			if enc == nil {
+				return nil, errors.Errorf("unsupported request content-coding %q", encoding)
+			}
+			if req.Body == nil || req.Body == http.NoBody || req.Header.Get("Content-Encoding") != "" {
+				return do(req)
+			}
+			if req.ContentLength > 0 && req.ContentLength < minSize {
+				return do(req)
+			}
+
+			b, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read body")
+			}
+
+			r := cloneRequest(req)
+			if int64(len(b)) < minSize {
+				r.Body = ioutil.NopCloser(bytes.NewReader(b))
+				return do(r)
+			}
+
+			var buf bytes.Buffer
+			w, err := enc(&buf)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to create %s encoder", encoding)
+			}
+			if _, err = w.Write(b); err == nil {
+				err = w.Close()
+			}
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to encode body with %s", encoding)
+			}
+
+			compressed := buf.Bytes()
+			r.Body = ioutil.NopCloser(bytes.NewReader(compressed))
+			r.GetBody = func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(compressed)), nil
+			}
+			r.ContentLength = int64(len(compressed))
+			r.Header = req.Header.Clone()
+			if r.Header == nil {
+				r.Header = make(http.Header)
+			}
+			r.Header.Set("Content-Encoding", encoding)
+			return do(r)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package apic_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kolach/apic"
+)
+
+// encode compresses s with given encoder
+func encode(enc Encoder, s string) []byte {
+	var buf bytes.Buffer
+	w, _ := enc(&buf)
+	w.Write([]byte(s))
+	w.Close()
+	return buf.Bytes()
+}
+
+// respondEncoded returns do function responding with encoded body
+func respondEncoded(encoding string, body []byte, sent *http.Request) DoFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		*sent = *req
+		res := &http.Response{
+			StatusCode:    200,
+			Header:        make(http.Header),
+			ContentLength: int64(len(body)),
+			Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		}
+		res.Header.Set("Content-Encoding", encoding)
+		return res, nil
+	}
+}
+
+var _ = Describe("Compression", func() {
+	const text = "Buy iPhoneX, Buy iPhoneX, Buy iPhoneX, Buy iPhoneX"
+
+	var (
+		req  *http.Request
+		sent http.Request
+	)
+
+	BeforeEach(func() {
+		req, _ = http.NewRequest("GET", "https://example.com/orders/1", nil)
+	})
+
+	Describe("WithDecompress", func() {
+		It("should advertise supported encodings", func() {
+			WithDecompress()(respondEncoded("", []byte(text), &sent))(req)
+			Ω(sent.Header.Get("Accept-Encoding")).Should(Equal("gzip, deflate, br, zstd"))
+			Ω(req.Header.Get("Accept-Encoding")).Should(BeEmpty())
+		})
+
+		It("should keep Accept-Encoding set by caller", func() {
+			req.Header.Set("Accept-Encoding", "gzip")
+			WithDecompress()(respondEncoded("", []byte(text), &sent))(req)
+			Ω(sent.Header.Get("Accept-Encoding")).Should(Equal("gzip"))
+		})
+
+		DescribeTable("should decode response body",
+			func(encoding string, body []byte) {
+				res, err := WithDecompress()(respondEncoded(encoding, body, &sent))(req)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(res.Header.Get("Content-Encoding")).Should(BeEmpty())
+				Ω(res.ContentLength).Should(Equal(int64(-1)))
+				Ω(res.Uncompressed).Should(BeTrue())
+
+				b, err := ioutil.ReadAll(res.Body)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(string(b)).Should(Equal(text))
+				Ω(res.Body.Close()).Should(Succeed())
+			},
+			Entry("gzip", "gzip", encode(GzipEncoder, text)),
+			Entry("deflate", "deflate", encode(DeflateEncoder, text)),
+			Entry("raw deflate", "deflate", encode(func(w io.Writer) (io.WriteCloser, error) {
+				return flate.NewWriter(w, flate.DefaultCompression)
+			}, text)),
+			Entry("br", "br", encode(BrotliEncoder, text)),
+			Entry("zstd", "zstd", encode(ZstdEncoder, text)),
+			Entry("gzip, br", "gzip, br", encode(BrotliEncoder, string(encode(GzipEncoder, text)))),
+		)
+
+		It("should leave unknown encodings as is", func() {
+			res, err := WithDecompress()(respondEncoded("lz4", []byte(text), &sent))(req)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(res.Header.Get("Content-Encoding")).Should(Equal("lz4"))
+			b, _ := ioutil.ReadAll(res.Body)
+			Ω(string(b)).Should(Equal(text))
+		})
+
+		It("should use registered decoders", func() {
+			upper := func(r io.Reader) (io.ReadCloser, error) {
+				b, _ := ioutil.ReadAll(r)
+				return ioutil.NopCloser(strings.NewReader(strings.ToUpper(string(b)))), nil
+			}
+			res, err := WithDecompress(WithDecoder("lz4", upper))(respondEncoded("lz4", []byte(text), &sent))(req)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(sent.Header.Get("Accept-Encoding")).Should(Equal("gzip, deflate, br, zstd, lz4"))
+			b, _ := ioutil.ReadAll(res.Body)
+			Ω(string(b)).Should(Equal(strings.ToUpper(text)))
+		})
+
+		It("should fail reading body beyond limit", func() {
+			res, err := WithDecompress(WithDecompressLimit(10))(respondEncoded("gzip", encode(GzipEncoder, text), &sent))(req)
+			Ω(err).ShouldNot(HaveOccurred())
+			b, err := ioutil.ReadAll(res.Body)
+			Ω(err).Should(Equal(ErrBodyTooLarge))
+			Ω(b).Should(HaveLen(10))
+		})
+
+		It("should read body of exactly limit size", func() {
+			res, _ := WithDecompress(WithDecompressLimit(int64(len(text))))(respondEncoded("gzip", encode(GzipEncoder, text), &sent))(req)
+			b, err := ioutil.ReadAll(res.Body)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(b)).Should(Equal(text))
+		})
+
+		It("should leave response to HEAD request as is", func() {
+			req.Method = "HEAD"
+			do := func(req *http.Request) (*http.Response, error) {
+				res := &http.Response{StatusCode: 200, Header: make(http.Header), ContentLength: 1024, Body: http.NoBody}
+				res.Header.Set("Content-Encoding", "gzip")
+				res.Header.Set("Content-Length", "1024")
+				return res, nil
+			}
+			res, err := WithDecompress()(do)(req)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(res.Header.Get("Content-Encoding")).Should(Equal("gzip"))
+			Ω(res.ContentLength).Should(Equal(int64(1024)))
+		})
+
+		It("should read empty encoded body of unknown length", func() {
+			do := respondEncoded("gzip", nil, &sent)
+			res, err := WithDecompress()(func(req *http.Request) (*http.Response, error) {
+				res, err := do(req)
+				res.ContentLength = -1
+				return res, err
+			})(req)
+			Ω(err).ShouldNot(HaveOccurred())
+			b, err := ioutil.ReadAll(res.Body)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(b).Should(BeEmpty())
+		})
+
+		It("should fail on corrupted body", func() {
+			_, err := WithDecompress()(respondEncoded("gzip", []byte(text), &sent))(req)
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Describe("WithCompressRequest", func() {
+		BeforeEach(func() {
+			req, _ = http.NewRequest("POST", "https://example.com/orders", strings.NewReader(text))
+		})
+
+		It("should compress large bodies", func() {
+			WithCompressRequest("gzip", 10)(respondEncoded("", nil, &sent))(req)
+			Ω(sent.Header.Get("Content-Encoding")).Should(Equal("gzip"))
+			Ω(req.Header.Get("Content-Encoding")).Should(BeEmpty())
+
+			zr, err := GzipDecoder(sent.Body)
+			Ω(err).ShouldNot(HaveOccurred())
+			b, _ := ioutil.ReadAll(zr)
+			Ω(string(b)).Should(Equal(text))
+			Ω(sent.ContentLength).ShouldNot(Equal(int64(len(text))))
+		})
+
+		It("should leave small bodies as is", func() {
+			WithCompressRequest("gzip", 1024)(respondEncoded("", nil, &sent))(req)
+			Ω(sent.Header.Get("Content-Encoding")).Should(BeEmpty())
+			b, _ := ioutil.ReadAll(sent.Body)
+			Ω(string(b)).Should(Equal(text))
+		})
+
+		It("should fail on unsupported encoding", func() {
+			_, err := WithCompressRequest("lzma", 10)(respondEncoded("", nil, &sent))(req)
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,157 @@
+package apic
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// StaleWarning is Warning header value marking responses served by WithStaleOnError
+const StaleWarning = `111 - "Revalidation Failed"`
+
+// IsFinalFailure treats transport errors, including open circuit, and 5xx responses
+// or 5xx StatusError as failures worth falling back on. Recovered panics are bugs
+// rather than upstream failures, so they are not.
+func IsFinalFailure(res *http.Response, err error) bool {
+	code := statusCode(res, err)
+	if err != nil {
+		if _, ok := errors.Cause(err).(*PanicError); ok {
+			return false
+		}
+		return code == 0 || code >= http.StatusInternalServerError
+	}
+	return res == nil || code >= http.StatusInternalServerError
+}
+
+// StaleFallback keeps last known good responses to serve them when upstream fails
+type StaleFallback struct {
+	store       CacheStore
+	key         KeyFunc
+	failure     FailureFunc
+	maxStale    time.Duration
+	maxBodySize int64
+}
+
+// FallbackOptionFunc is functional type to configure stale fallback
+type FallbackOptionFunc func(f *StaleFallback)
+
+// WithMaxStaleness limits age of response to fall back on, default is no limit
+func WithMaxStaleness(d time.Duration) FallbackOptionFunc {
+	return func(f *StaleFallback) {
+		f.maxStale = d
+	}
+}
+
+// WithFallbackKey sets function keying stored responses, default is method and URL
+func WithFallbackKey(fn KeyFunc) FallbackOptionFunc {
+	return func(f *StaleFallback) {
+		f.key = fn
+	}
+}
+
+// WithFallbackFailure sets function deciding when to fall back, default is IsFinalFailure
+func WithFallbackFailure(fn FailureFunc) FallbackOptionFunc {
+	return func(f *StaleFallback) {
+		f.failure = fn
+	}
+}
+
+// WithFallbackMaxBodySize sets max body size of responses to store
+func WithFallbackMaxBodySize(n int64) FallbackOptionFunc {
+	return func(f *StaleFallback) {
+		f.maxBodySize = n
+	}
+}
+
+// NewStaleFallback constructs stale fallback backed by given store
+func NewStaleFallback(store CacheStore, opts ...FallbackOptionFunc) *StaleFallback {
+	f := &StaleFallback{
+		store:       store,
+		key:         cacheKey,
+		failure:     IsFinalFailure,
+		maxBodySize: DefaultCacheMaxBodySize,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+// save remembers successful response
+func (f *StaleFallback) save(req *http.Request, res *http.Response, requestTime time.Time) error {
+	e, err := newCacheEntry(req, res, requestTime, f.maxBodySize)
+	if err != nil || e == nil {
+		return err
+	}
+	if b, err := json.Marshal(e); err == nil {
+		f.store.Set(f.key(req), b)
+	}
+	return nil
+}
+
+// load returns stored response not older than max staleness
+func (f *StaleFallback) load(req *http.Request, now time.Time) *cacheEntry {
+	b, ok := f.store.Get(f.key(req))
+	if !ok {
+		return nil
+	}
+	e := new(cacheEntry)
+	if err := json.Unmarshal(b, e); err != nil || !e.matches(req) {
+		return nil
+	}
+	if f.maxStale > 0 && e.age(now) > f.maxStale {
+		return nil
+	}
+	return e
+}
+
+// WithStaleOnError stores successful GET responses and serves the last one
+// when request finally fails. Served response is marked with CacheStatusHeader
+// set to CacheStale, Age header and StaleWarning in Warning header.
+// Requests canceled or timed out by caller context are failed as is.
+// To fall back only after all retries are exhausted, configure it on the client
+// with WithStaleFallback, so it wraps the retry interceptor.
+// Usage example:
+//
+// f := NewStaleFallback(NewMemoryCacheStore(16 << 20), WithMaxStaleness(time.Hour))
+// ...
+// res, err := client.Do(req, WithStaleOnError(f))
+//
+func WithStaleOnError(f *StaleFallback) InterceptDoFunc {
+	return func(do DoFunc) DoFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			if req.Method != http.MethodGet {
+				return do(req)
+			}
+
+			requestTime := time.Now()
+			res, err := do(req)
+			if req.Context().Err() != nil || !f.failure(res, err) {
+				if err == nil && res.StatusCode >= 200 && res.StatusCode < 300 {
+					if err := f.save(req, res, requestTime); err != nil {
+						return nil, err
+					}
+				}
+				return res, err
+			}
+
+			now := time.Now()
+			e := f.load(req, now)
+			if e == nil {
+				return res, err
+			}
+			if res != nil {
+				discardResponse(res)
+			}
+			if re, ok := errors.Cause(err).(*ResponseError); ok && re.Response != nil {
+				// exhausted retries may hand response over inside error
+				discardResponse(re.Response)
+			}
+			stale := e.response(req, CacheStale, now)
+			stale.Header.Add("Warning", StaleWarning)
+			return stale, nil
+		}
+	}
+}
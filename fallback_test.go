@@ -0,0 +1,148 @@
+package apic_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kolach/apic"
+)
+
+var _ = Describe("WithStaleOnError", func() {
+	var (
+		req    *http.Request
+		status int
+		err    error
+		count  int
+		do     DoFunc
+	)
+
+	BeforeEach(func() {
+		req, _ = http.NewRequest("GET", "https://example.com/orders/1", nil)
+		status, err, count = http.StatusOK, nil, 0
+		do = func(req *http.Request) (*http.Response, error) {
+			count++
+			if err != nil {
+				return nil, err
+			}
+			return &http.Response{
+				StatusCode: status,
+				Header:     http.Header{"Date": {time.Now().UTC().Format(http.TimeFormat)}},
+				Body:       ioutil.NopCloser(bytes.NewBufferString(fmt.Sprintf("test %d", count))),
+			}, nil
+		}
+	})
+
+	body := func(res *http.Response) string {
+		b, _ := ioutil.ReadAll(res.Body)
+		return string(b)
+	}
+
+	It("should serve last good response on transport error", func() {
+		f := NewStaleFallback(NewMemoryCacheStore(1 << 20))
+		res, e := WithStaleOnError(f)(do)(req)
+		Ω(e).ShouldNot(HaveOccurred())
+		Ω(body(res)).Should(Equal("test 1"))
+
+		err = fmt.Errorf("Error")
+		res, e = WithStaleOnError(f)(do)(req)
+		Ω(e).ShouldNot(HaveOccurred())
+		Ω(body(res)).Should(Equal("test 1"))
+		Ω(res.Header.Get(CacheStatusHeader)).Should(Equal(CacheStale))
+		Ω(res.Header.Get("Warning")).Should(Equal(StaleWarning))
+		Ω(res.Header.Get("Age")).Should(Equal("0"))
+	})
+
+	It("should serve last good response on 5xx StatusError and open circuit", func() {
+		f := NewStaleFallback(NewMemoryCacheStore(1 << 20))
+		WithStaleOnError(f)(do)(req)
+
+		status = http.StatusBadGateway
+		res, e := WithStaleOnError(f)(WithExpectStatus(http.StatusOK)(do))(req)
+		Ω(e).ShouldNot(HaveOccurred())
+		Ω(body(res)).Should(Equal("test 1"))
+
+		err = ErrCircuitOpen
+		res, e = WithStaleOnError(f)(do)(req)
+		Ω(e).ShouldNot(HaveOccurred())
+		Ω(body(res)).Should(Equal("test 1"))
+	})
+
+	It("should not fall back on client errors", func() {
+		f := NewStaleFallback(NewMemoryCacheStore(1 << 20))
+		WithStaleOnError(f)(do)(req)
+
+		status = http.StatusNotFound
+		_, e := WithStaleOnError(f)(WithExpectStatus(http.StatusOK)(do))(req)
+		statusErr, ok := e.(*StatusError)
+		Ω(ok).Should(BeTrue())
+		Ω(statusErr.StatusCode).Should(Equal(http.StatusNotFound))
+	})
+
+	It("should not serve response older than max staleness", func() {
+		f := NewStaleFallback(NewMemoryCacheStore(1<<20), WithMaxStaleness(time.Millisecond))
+		WithStaleOnError(f)(do)(req)
+		time.Sleep(5 * time.Millisecond)
+
+		err = fmt.Errorf("Error")
+		_, e := WithStaleOnError(f)(do)(req)
+		Ω(e).Should(MatchError("Error"))
+	})
+
+	It("should not fall back when caller cancels request", func() {
+		f := NewStaleFallback(NewMemoryCacheStore(1 << 20))
+		WithStaleOnError(f)(do)(req)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err = context.Canceled
+		res, e := WithStaleOnError(f)(do)(req.WithContext(ctx))
+		Ω(res).Should(BeNil())
+		Ω(e).Should(Equal(context.Canceled))
+	})
+
+	It("should not fall back on recovered panic", func() {
+		f := NewStaleFallback(NewMemoryCacheStore(1 << 20))
+		WithStaleOnError(f)(do)(req)
+
+		panicky := func(*http.Request) (*http.Response, error) { panic("boom") }
+		res, e := WithStaleOnError(f)(WithRecover()(panicky))(req)
+		Ω(res).Should(BeNil())
+		_, ok := e.(*PanicError)
+		Ω(ok).Should(BeTrue())
+	})
+
+	It("should close response of ResponseError when serving stale one", func() {
+		f := NewStaleFallback(NewMemoryCacheStore(1 << 20))
+		WithStaleOnError(f)(do)(req)
+
+		body := &closeTracker{Reader: bytes.NewReader([]byte("test"))}
+		failed := func(*http.Request) (*http.Response, error) {
+			return nil, &ResponseError{Response: &http.Response{StatusCode: http.StatusServiceUnavailable, Body: body}}
+		}
+		res, e := WithStaleOnError(f)(failed)(req)
+		Ω(e).ShouldNot(HaveOccurred())
+		Ω(res.Header.Get(CacheStatusHeader)).Should(Equal(CacheStale))
+		Ω(body.isClosed()).Should(BeTrue())
+	})
+
+	It("should fall back after retries are exhausted when configured on client", func() {
+		f := NewStaleFallback(NewMemoryCacheStore(1 << 20))
+		client := NewClient(WithConstantBackOff(time.Millisecond), WithMaxRetries(2), WithStaleFallback(f))
+		intercept := func(DoFunc) DoFunc { return do }
+		_, e := client.Do(req, intercept)
+		Ω(e).ShouldNot(HaveOccurred())
+
+		err = fmt.Errorf("Error")
+		res, e := client.Do(req, intercept)
+		Ω(e).ShouldNot(HaveOccurred())
+		Ω(body(res)).Should(Equal("test 1"))
+		Ω(count).Should(Equal(4))
+	})
+})
@@ -3,7 +3,9 @@ module github.com/kolach/apic
 go 1.13
 
 require (
+	github.com/andybalholm/brotli v1.0.4
 	github.com/cenkalti/backoff v2.2.1+incompatible
+	github.com/klauspost/compress v1.11.13
 	github.com/kolach/gomega-matchers v0.0.34
 	github.com/onsi/ginkgo v1.10.3
 	github.com/onsi/gomega v1.7.1
@@ -0,0 +1,272 @@
+package apic
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ProbeFunc builds health check request to given endpoint
+type ProbeFunc func(ctx context.Context, endpoint string) (*http.Request, error)
+
+// HealthChangeFunc is called when endpoint health changes
+type HealthChangeFunc func(endpoint string, healthy bool)
+
+// isUnhealthy treats errors and non 2xx responses as failed probes
+func isUnhealthy(res *http.Response, err error) bool {
+	return err != nil || res == nil || res.StatusCode < 200 || res.StatusCode >= 300
+}
+
+// EndpointHealth is snapshot of endpoint health
+type EndpointHealth struct {
+	URL       string
+	Healthy   bool
+	Successes int // consecutive successful probes
+	Failures  int // consecutive failed probes
+	LastError error
+	LastCheck time.Time
+}
+
+// HealthChecker periodically probes endpoints and tracks their health.
+// Endpoint changes state only after a number of consecutive probes agree,
+// so a single blip doesn't flap it. Endpoints start healthy.
+type HealthChecker struct {
+	client             *Client
+	resolve            EndpointsFunc
+	probe              ProbeFunc
+	failure            FailureFunc
+	interval           time.Duration
+	timeout            time.Duration
+	healthyThreshold   int
+	unhealthyThreshold int
+	onChange           HealthChangeFunc
+
+	mu     sync.Mutex
+	health map[string]*EndpointHealth
+}
+
+// HealthOptionFunc is functional type to configure health checker
+type HealthOptionFunc func(h *HealthChecker)
+
+// WithHealthInterval sets time between probe rounds, default is 10 seconds
+func WithHealthInterval(d time.Duration) HealthOptionFunc {
+	return func(h *HealthChecker) {
+		h.interval = d
+	}
+}
+
+// WithHealthTimeout sets timeout of a single probe, default is 2 seconds
+func WithHealthTimeout(d time.Duration) HealthOptionFunc {
+	return func(h *HealthChecker) {
+		h.timeout = d
+	}
+}
+
+// WithHealthThresholds sets how many consecutive probes it takes to mark endpoint
+// healthy and unhealthy, default is 2 and 3
+func WithHealthThresholds(healthy, unhealthy int) HealthOptionFunc {
+	return func(h *HealthChecker) {
+		h.healthyThreshold = healthy
+		h.unhealthyThreshold = unhealthy
+	}
+}
+
+// WithHealthFailure sets function deciding whether probe failed,
+// default treats errors and non 2xx responses as failures
+func WithHealthFailure(fn FailureFunc) HealthOptionFunc {
+	return func(h *HealthChecker) {
+		h.failure = fn
+	}
+}
+
+// WithHealthChange sets callback called on endpoint health changes
+func WithHealthChange(fn HealthChangeFunc) HealthOptionFunc {
+	return func(h *HealthChecker) {
+		h.onChange = fn
+	}
+}
+
+// NewHealthChecker constructs health checker probing endpoints with requests
+// built by probe function and performed with client.
+// Usage example:
+//
+// hc := NewHealthChecker(client, StaticEndpoints("https://a.example.com", "https://b.example.com"),
+// 	func(ctx context.Context, endpoint string) (*http.Request, error) {
+// 		return NewRequest("GET", endpoint+"/health", nil, WithContext(ctx))
+// 	})
+// go hc.Run(ctx)
+// b := NewBalancer(hc.HealthyEndpoints)
+//
+func NewHealthChecker(client *Client, resolve EndpointsFunc, probe ProbeFunc, opts ...HealthOptionFunc) *HealthChecker {
+	h := &HealthChecker{
+		client:             client,
+		resolve:            resolve,
+		probe:              probe,
+		failure:            isUnhealthy,
+		interval:           10 * time.Second,
+		timeout:            2 * time.Second,
+		healthyThreshold:   2,
+		unhealthyThreshold: 3,
+		health:             make(map[string]*EndpointHealth),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Run probes endpoints every interval until context is done.
+// It waits for probes in flight and returns context error.
+func (h *HealthChecker) Run(ctx context.Context) error {
+	t := time.NewTicker(h.interval)
+	defer t.Stop()
+	for {
+		h.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Check runs a single round of probes concurrently and waits for it to complete
+func (h *HealthChecker) Check(ctx context.Context) {
+	urls, err := h.resolve()
+	if err != nil {
+		return
+	}
+	h.sync(urls)
+
+	var wg sync.WaitGroup
+	for _, u := range urls {
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+			h.check(ctx, u)
+		}(u)
+	}
+	wg.Wait()
+}
+
+// sync adds newly resolved endpoints and forgets gone ones
+func (h *HealthChecker) sync(urls []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	seen := make(map[string]bool, len(urls))
+	for _, u := range urls {
+		seen[u] = true
+		if _, ok := h.health[u]; !ok {
+			h.health[u] = &EndpointHealth{URL: u, Healthy: true}
+		}
+	}
+	for u := range h.health {
+		if !seen[u] {
+			delete(h.health, u)
+		}
+	}
+}
+
+// check probes single endpoint and records result
+func (h *HealthChecker) check(ctx context.Context, u string) {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	req, err := h.probe(ctx, u)
+	var res *http.Response
+	if err == nil {
+		res, err = h.client.Do(req.WithContext(ctx))
+	}
+	failed := h.failure(res, err)
+	if res != nil {
+		discardResponse(res)
+	}
+	if ctx.Err() == context.Canceled {
+		// checker is stopped, the probe tells nothing about endpoint
+		return
+	}
+	if failed && err == nil && res != nil {
+		err = &StatusError{StatusCode: res.StatusCode, Status: res.Status}
+	}
+	h.record(u, failed, err)
+}
+
+// record updates endpoint health with probe result
+func (h *HealthChecker) record(u string, failed bool, err error) {
+	h.mu.Lock()
+	e, ok := h.health[u]
+	if !ok {
+		h.mu.Unlock()
+		return
+	}
+	e.LastCheck = time.Now()
+	e.LastError = err
+	changed := false
+	if failed {
+		e.Successes = 0
+		e.Failures++
+		if e.Healthy && e.Failures >= h.unhealthyThreshold {
+			e.Healthy, changed = false, true
+		}
+	} else {
+		e.Failures = 0
+		e.Successes++
+		if !e.Healthy && e.Successes >= h.healthyThreshold {
+			e.Healthy, changed = true, true
+		}
+	}
+	healthy := e.Healthy
+	h.mu.Unlock()
+
+	if changed && h.onChange != nil {
+		h.onChange(u, healthy)
+	}
+}
+
+// Healthy tells if endpoint is healthy, unknown endpoints are not
+func (h *HealthChecker) Healthy(endpoint string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e, ok := h.health[endpoint]
+	return ok && e.Healthy
+}
+
+// Status returns health snapshot of endpoints
+func (h *HealthChecker) Status() []EndpointHealth {
+	urls, err := h.resolve()
+	if err != nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	status := make([]EndpointHealth, 0, len(urls))
+	for _, u := range urls {
+		if e, ok := h.health[u]; ok {
+			status = append(status, *e)
+		}
+	}
+	return status
+}
+
+// HealthyEndpoints returns healthy endpoints. If none are healthy it returns all of them,
+// so requests still have a chance. Pass it to NewBalancer as EndpointsFunc.
+func (h *HealthChecker) HealthyEndpoints() ([]string, error) {
+	urls, err := h.resolve()
+	if err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var healthy []string
+	for _, u := range urls {
+		// endpoints not probed yet are given a chance
+		if e, ok := h.health[u]; !ok || e.Healthy {
+			healthy = append(healthy, u)
+		}
+	}
+	if len(healthy) == 0 {
+		return urls, nil
+	}
+	return healthy, nil
+}
@@ -0,0 +1,111 @@
+package apic_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kolach/apic"
+)
+
+// roundTripFunc is http.RoundTripper implemented by a function
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (fn roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
+
+var _ = Describe("HealthChecker", func() {
+	var (
+		mu      sync.Mutex
+		down    map[string]bool
+		client  *Client
+		changes []string
+		hc      *HealthChecker
+	)
+
+	setDown := func(host string, v bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		down[host] = v
+	}
+
+	BeforeEach(func() {
+		down, changes = make(map[string]bool), nil
+		client = NewClient(WithHTTPClient(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			status := http.StatusOK
+			if down[req.URL.Host] {
+				status = http.StatusServiceUnavailable
+			}
+			return &http.Response{StatusCode: status, Body: ioutil.NopCloser(strings.NewReader("")), Request: req}, nil
+		})}))
+		probe := func(ctx context.Context, endpoint string) (*http.Request, error) {
+			return NewRequest("GET", endpoint+"/health", nil, WithContext(ctx))
+		}
+		hc = NewHealthChecker(client, StaticEndpoints("https://a.example.com", "https://b.example.com"), probe,
+			WithHealthThresholds(2, 2),
+			WithHealthChange(func(endpoint string, healthy bool) {
+				mu.Lock()
+				defer mu.Unlock()
+				changes = append(changes, endpoint)
+			}))
+	})
+
+	It("should mark endpoint unhealthy and back with hysteresis", func() {
+		ctx := context.Background()
+		setDown("a.example.com", true)
+		hc.Check(ctx)
+		Ω(hc.Healthy("https://a.example.com")).Should(BeTrue())
+		hc.Check(ctx)
+		Ω(hc.Healthy("https://a.example.com")).Should(BeFalse())
+		Ω(hc.Healthy("https://b.example.com")).Should(BeTrue())
+
+		status := hc.Status()
+		Ω(status[0].Failures).Should(Equal(2))
+		Ω(status[0].LastError).Should(HaveOccurred())
+		endpoints, _ := hc.HealthyEndpoints()
+		Ω(endpoints).Should(Equal([]string{"https://b.example.com"}))
+
+		setDown("a.example.com", false)
+		hc.Check(ctx)
+		Ω(hc.Healthy("https://a.example.com")).Should(BeFalse())
+		hc.Check(ctx)
+		Ω(hc.Healthy("https://a.example.com")).Should(BeTrue())
+		Ω(changes).Should(Equal([]string{"https://a.example.com", "https://a.example.com"}))
+	})
+
+	It("should return all endpoints when none is healthy", func() {
+		setDown("a.example.com", true)
+		setDown("b.example.com", true)
+		hc.Check(context.Background())
+		hc.Check(context.Background())
+		endpoints, _ := hc.HealthyEndpoints()
+		Ω(endpoints).Should(HaveLen(2))
+	})
+
+	It("should probe periodically until context is canceled", func() {
+		hc = NewHealthChecker(client, StaticEndpoints("https://a.example.com"),
+			func(ctx context.Context, endpoint string) (*http.Request, error) {
+				return NewRequest("GET", endpoint, nil, WithContext(ctx))
+			}, WithHealthInterval(5*time.Millisecond), WithHealthThresholds(1, 1))
+		setDown("a.example.com", true)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- hc.Run(ctx) }()
+		Eventually(func() bool { return hc.Healthy("https://a.example.com") }).Should(BeFalse())
+		setDown("a.example.com", false)
+		Eventually(func() bool { return hc.Healthy("https://a.example.com") }).Should(BeTrue())
+
+		cancel()
+		Eventually(done).Should(Receive(Equal(context.Canceled)))
+	})
+})
@@ -0,0 +1,277 @@
+package apic
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Hedger decides when to fire duplicate requests and keeps track of hedging budget
+type Hedger struct {
+	mu         sync.Mutex
+	delay      time.Duration   // fixed delay before hedging
+	percentile float64         // latency percentile to use as delay, 0 disables
+	samples    []time.Duration // ring buffer of recent latencies
+	next       int             // next position in samples
+	filled     bool            // true when samples buffer is full
+	maxHedges  int             // max hedges per request
+	budget     float64         // max ratio of hedges to requests
+	requests   int64           // requests seen
+	hedges     int64           // hedges fired
+}
+
+// HedgeOptionFunc is functional type to configure hedger
+type HedgeOptionFunc func(h *Hedger)
+
+// WithHedgePercentile derives hedging delay from given percentile (0..100)
+// of last window successful request latencies. Fixed delay is used till the window is filled.
+func WithHedgePercentile(p float64, window int) HedgeOptionFunc {
+	return func(h *Hedger) {
+		h.percentile = p
+		h.samples = make([]time.Duration, window)
+	}
+}
+
+// WithMaxHedges sets how many duplicates of a single request can be fired
+func WithMaxHedges(n int) HedgeOptionFunc {
+	return func(h *Hedger) {
+		h.maxHedges = n
+	}
+}
+
+// WithHedgeBudget caps total number of hedges to a ratio of requests
+func WithHedgeBudget(ratio float64) HedgeOptionFunc {
+	return func(h *Hedger) {
+		h.budget = ratio
+	}
+}
+
+// NewHedger constructs hedger firing a duplicate request if the first one
+// takes longer than delay. By default only 1 hedge per request is allowed
+// and hedges can't exceed 10% of requests.
+func NewHedger(delay time.Duration, opts ...HedgeOptionFunc) *Hedger {
+	h := &Hedger{delay: delay, maxHedges: 1, budget: 0.1}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Delay returns current hedging delay
+func (h *Hedger) Delay() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.percentile <= 0 || !h.filled {
+		return h.delay
+	}
+	sorted := make([]time.Duration, len(h.samples))
+	copy(sorted, h.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(h.percentile / 100 * float64(len(sorted)-1))
+	return sorted[i]
+}
+
+// observe records successful request latency
+func (h *Hedger) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.samples) == 0 {
+		return
+	}
+	h.samples[h.next] = d
+	if h.next = (h.next + 1) % len(h.samples); h.next == 0 {
+		h.filled = true
+	}
+}
+
+// request counts a request
+func (h *Hedger) request() {
+	h.mu.Lock()
+	h.requests++
+	h.mu.Unlock()
+}
+
+// allow checks hedging budget and counts a hedge if it is allowed
+func (h *Hedger) allow() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if float64(h.hedges+1) > h.budget*float64(h.requests) {
+		return false
+	}
+	h.hedges++
+	return true
+}
+
+// hedgeResult is outcome of a single attempt
+type hedgeResult struct {
+	index    int // attempt number, 0 is the original request
+	res      *http.Response
+	err      error
+	panicked *PanicError // panic recovered in attempt goroutine
+	cancel   context.CancelFunc
+	elapsed  time.Duration
+}
+
+// succeeded tells if attempt result can be returned to caller right away
+func (r *hedgeResult) succeeded() bool {
+	return r.err == nil && r.res.StatusCode < http.StatusInternalServerError
+}
+
+// discard releases attempt resources
+func (r *hedgeResult) discard() {
+	if r.res != nil && r.res.Body != nil {
+		r.res.Body.Close()
+	}
+	r.cancel()
+}
+
+// response hands attempt result over to caller, attempt context
+// is canceled when response body is closed.
+func (r *hedgeResult) response() (*http.Response, error) {
+	if r.res == nil || r.res.Body == nil {
+		r.cancel()
+		return r.res, r.err
+	}
+	r.res.Body = &cancelOnClose{ReadCloser: r.res.Body, cancel: r.cancel}
+	return r.res, r.err
+}
+
+// cancelOnClose cancels request context once response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close closes body and cancels context
+func (r *cancelOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.cancel()
+	return err
+}
+
+// WithHedging fires duplicate request via the same do function if the first
+// attempt hasn't responded within hedger delay and returns whichever
+// succeeds first. Losers are canceled and their bodies closed.
+// Use it for idempotent requests only. Requests with body are hedged only if
+// the body can be replayed with req.GetBody. Panic of an attempt is re-raised on
+// caller goroutine, or returned as *PanicError under WithRecover.
+// Usage example:
+//
+// h := NewHedger(50*time.Millisecond, WithHedgePercentile(95, 100), WithMaxHedges(2))
+// ...
+// res, err := c.Do(req, WithHedging(h))
+//
+func WithHedging(h *Hedger) InterceptDoFunc {
+	return func(do DoFunc) DoFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+				return do(req)
+			}
+			h.request()
+
+			results := make(chan *hedgeResult, h.maxHedges+1)
+			var cancels []context.CancelFunc
+			launch := func() error {
+				ctx, cancel := context.WithCancel(req.Context())
+				r := req.WithContext(ctx)
+				if len(cancels) > 0 && req.GetBody != nil {
+					body, err := req.GetBody()
+					if err != nil {
+						cancel()
+						return errors.Wrap(err, "failed to get body for hedged request")
+					}
+					r.Body = body
+				}
+				index := len(cancels)
+				cancels = append(cancels, cancel)
+
+				go func() {
+					result := &hedgeResult{index: index, cancel: cancel}
+					start := time.Now()
+					defer func() {
+						// panic can't cross goroutines, hand it over to caller one
+						if v := recover(); v != nil {
+							result.panicked = newPanicError(v)
+							result.res, result.err = nil, result.panicked
+						}
+						result.elapsed = time.Since(start)
+						results <- result
+					}()
+					result.res, result.err = do(r)
+				}()
+				return nil
+			}
+
+			if err := launch(); err != nil {
+				return nil, err
+			}
+			pending, hedges := 1, 0
+
+			delay := h.Delay()
+			t := time.NewTimer(delay)
+			defer t.Stop()
+
+			var last *hedgeResult
+			for {
+				select {
+				case r := <-results:
+					pending--
+					if r.panicked != nil && !recovering(req.Context()) {
+						// panic recovery is not requested, re-raise it on caller goroutine
+						if last != nil {
+							last.discard()
+						}
+						for _, cancel := range cancels {
+							cancel()
+						}
+						go func(n int) {
+							for ; n > 0; n-- {
+								(<-results).discard()
+							}
+						}(pending)
+						panic(r.panicked.Value)
+					}
+					if r.succeeded() {
+						h.observe(r.elapsed)
+						if last != nil {
+							last.discard()
+						}
+						// cancel losers and clean up after them in background
+						for i, cancel := range cancels {
+							if i != r.index {
+								cancel()
+							}
+						}
+						go func(n int) {
+							for ; n > 0; n-- {
+								(<-results).discard()
+							}
+						}(pending)
+						return r.response()
+					}
+
+					if last != nil {
+						last.discard()
+					}
+					last = r
+					if pending == 0 {
+						return last.response()
+					}
+				case <-t.C:
+					if hedges < h.maxHedges && h.allow() {
+						if err := launch(); err == nil {
+							hedges++
+							pending++
+							t.Reset(delay)
+						}
+					}
+				}
+			}
+		}
+	}
+}
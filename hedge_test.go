@@ -0,0 +1,171 @@
+package apic_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kolach/apic"
+)
+
+// closeTracker is response body remembering if it was closed
+type closeTracker struct {
+	*bytes.Reader
+	mu     sync.Mutex
+	closed bool
+}
+
+func (b *closeTracker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	return nil
+}
+
+func (b *closeTracker) isClosed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
+
+var _ = Describe("WithHedging", func() {
+	var (
+		req    *http.Request
+		mu     sync.Mutex
+		calls  int
+		bodies []*closeTracker
+	)
+
+	// respondAfter responds to n-th call after given delay
+	respondAfter := func(delays ...time.Duration) DoFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			n := calls
+			calls++
+			body := &closeTracker{Reader: bytes.NewReader([]byte{byte('0' + n)})}
+			bodies = append(bodies, body)
+			mu.Unlock()
+
+			select {
+			case <-time.After(delays[n]):
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: body}, nil
+		}
+	}
+
+	callCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls
+	}
+
+	BeforeEach(func() {
+		calls = 0
+		bodies = nil
+		req, _ = http.NewRequest("GET", "https://example.com/orders/1", nil)
+	})
+
+	It("should not hedge fast requests", func() {
+		h := NewHedger(50*time.Millisecond, WithHedgeBudget(1))
+		res, err := WithHedging(h)(respondAfter(0))(req)
+		Ω(err).ShouldNot(HaveOccurred())
+		b, _ := ioutil.ReadAll(res.Body)
+		Ω(string(b)).Should(Equal("0"))
+		Ω(callCount()).Should(Equal(1))
+	})
+
+	It("should return hedged response if it comes first", func() {
+		h := NewHedger(10*time.Millisecond, WithHedgeBudget(1))
+		res, err := WithHedging(h)(respondAfter(time.Second, 0))(req)
+		Ω(err).ShouldNot(HaveOccurred())
+		b, _ := ioutil.ReadAll(res.Body)
+		Ω(string(b)).Should(Equal("1"))
+		Ω(callCount()).Should(Equal(2))
+	})
+
+	It("should cancel the loser and close its body", func() {
+		h := NewHedger(10*time.Millisecond, WithHedgeBudget(1))
+		res, err := WithHedging(h)(respondAfter(30*time.Millisecond, 0))(req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		mu.Lock()
+		winner, loser := bodies[1], bodies[0]
+		mu.Unlock()
+		Ω(winner.isClosed()).Should(BeFalse())
+		res.Body.Close()
+		Ω(winner.isClosed()).Should(BeTrue())
+		Consistently(loser.isClosed, 50*time.Millisecond).Should(BeFalse()) // canceled before response
+	})
+
+	It("should respect max hedges", func() {
+		h := NewHedger(5*time.Millisecond, WithHedgeBudget(10), WithMaxHedges(2))
+		res, err := WithHedging(h)(respondAfter(100*time.Millisecond, 100*time.Millisecond, 100*time.Millisecond, 0))(req)
+		Ω(err).ShouldNot(HaveOccurred())
+		res.Body.Close()
+		Ω(callCount()).Should(Equal(3))
+	})
+
+	It("should respect hedging budget", func() {
+		h := NewHedger(5*time.Millisecond, WithHedgeBudget(0.5))
+		delays := []time.Duration{20 * time.Millisecond, 20 * time.Millisecond}
+		for i := 0; i < 2; i++ {
+			calls = 0
+			res, err := WithHedging(h)(respondAfter(delays...))(req)
+			Ω(err).ShouldNot(HaveOccurred())
+			res.Body.Close()
+			if i == 0 {
+				Ω(callCount()).Should(Equal(1)) // 1 hedge of 1 request is over 50%
+			} else {
+				Ω(callCount()).Should(Equal(2))
+			}
+		}
+	})
+
+	It("should derive delay from latency percentile", func() {
+		h := NewHedger(time.Second, WithHedgePercentile(50, 3))
+		for _, d := range []time.Duration{10, 20, 30} {
+			res, _ := WithHedging(h)(func(req *http.Request) (*http.Response, error) {
+				time.Sleep(d * time.Millisecond)
+				return &http.Response{StatusCode: http.StatusOK}, nil
+			})(req)
+			Ω(res.StatusCode).Should(Equal(http.StatusOK))
+		}
+		Ω(h.Delay()).Should(BeNumerically("~", 20*time.Millisecond, 10*time.Millisecond))
+	})
+
+	It("should not hedge requests with body that can't be replayed", func() {
+		h := NewHedger(time.Millisecond, WithHedgeBudget(1))
+		req, _ := http.NewRequest("POST", "https://example.com/orders", nil)
+		req.Body = ioutil.NopCloser(bytes.NewBufferString("Buy iPhoneX"))
+		res, err := WithHedging(h)(respondAfter(20 * time.Millisecond))(req)
+		Ω(err).ShouldNot(HaveOccurred())
+		res.Body.Close()
+		Ω(callCount()).Should(Equal(1))
+	})
+
+	It("should re-raise panic of attempt on caller goroutine", func() {
+		h := NewHedger(time.Second)
+		panicky := func(*http.Request) (*http.Response, error) { panic("boom") }
+		var v interface{}
+		func() {
+			defer func() { v = recover() }()
+			WithHedging(h)(panicky)(req)
+		}()
+		Ω(v).Should(Equal("boom"))
+	})
+
+	It("should return panic of attempt as PanicError under WithRecover", func() {
+		h := NewHedger(time.Second)
+		panicky := func(*http.Request) (*http.Response, error) { panic("boom") }
+		res, err := WithRecover()(WithHedging(h)(panicky))(req)
+		Ω(res).Should(BeNil())
+		Ω(err).Should(MatchError("panic: boom"))
+	})
+})
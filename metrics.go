@@ -0,0 +1,128 @@
+package apic
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Metric names reported by WithMetrics
+const (
+	MetricRequests = "apic_requests_total"           // counter of attempts by status class
+	MetricDuration = "apic_request_duration_seconds" // histogram of time till response headers
+	MetricInFlight = "apic_requests_in_flight"       // gauge of attempts waiting for response
+	MetricRetries  = "apic_retries_total"            // counter of retry attempts
+	MetricErrors   = "apic_errors_total"             // counter of transport errors
+)
+
+// MetricsSink receives metrics, labels map label names to values
+type MetricsSink interface {
+	// AddCounter adds delta to counter
+	AddCounter(name string, labels map[string]string, delta float64)
+	// AddGauge adds delta to gauge, delta may be negative
+	AddGauge(name string, labels map[string]string, delta float64)
+	// Observe records histogram sample
+	Observe(name string, labels map[string]string, value float64)
+}
+
+// routeTemplateKey is context key of request route template
+type routeTemplateKey struct{}
+
+// WithRouteTemplate tags request with route template like "/orders/{id}",
+// used as route label of metrics instead of raw path to keep label cardinality low.
+// Usage example:
+//
+// req, err := NewRequest("GET", "/orders/"+id, nil, WithRouteTemplate("/orders/{id}"))
+//
+func WithRouteTemplate(template string) RequestOptionFunc {
+	return func(req *http.Request) (*http.Request, error) {
+		return req.WithContext(context.WithValue(req.Context(), routeTemplateKey{}, template)), nil
+	}
+}
+
+// RouteTemplate returns route template request was tagged with, or empty string
+func RouteTemplate(ctx context.Context) string {
+	template, _ := ctx.Value(routeTemplateKey{}).(string)
+	return template
+}
+
+// TemplateRoute is route label function returning route template of request or "other"
+func TemplateRoute(req *http.Request) string {
+	if template := RouteTemplate(req.Context()); template != "" {
+		return template
+	}
+	return "other"
+}
+
+// statusClass returns status class label like "2xx", or "error" for transport errors
+func statusClass(res *http.Response, err error) string {
+	if err != nil || res == nil {
+		return "error"
+	}
+	return fmt.Sprintf("%dxx", res.StatusCode/100)
+}
+
+// metricsConfig is metrics interceptor configuration
+type metricsConfig struct {
+	route KeyFunc
+}
+
+// MetricsOptionFunc is functional type to configure metrics interceptor
+type MetricsOptionFunc func(cfg *metricsConfig)
+
+// WithMetricsRoute sets function computing route label, default is TemplateRoute
+func WithMetricsRoute(fn KeyFunc) MetricsOptionFunc {
+	return func(cfg *metricsConfig) {
+		cfg.route = fn
+	}
+}
+
+// WithMetrics reports request metrics labeled with method, host, route and,
+// where known, status class. Within client interceptors it runs once per attempt,
+// so attempts after the first are counted as retries.
+// Usage example:
+//
+// sink := NewMemoryMetricsSink()
+// ...
+// res, err := c.Do(req, WithMetrics(sink))
+// ...
+// sink.WritePrometheus(w)
+//
+func WithMetrics(sink MetricsSink, opts ...MetricsOptionFunc) InterceptDoFunc {
+	cfg := &metricsConfig{route: TemplateRoute}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	return func(do DoFunc) DoFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			labels := map[string]string{
+				"method": req.Method,
+				"host":   req.URL.Host,
+				"route":  cfg.route(req),
+			}
+			if RetryAttempt(req.Context()) > 1 {
+				sink.AddCounter(MetricRetries, labels, 1)
+			}
+
+			sink.AddGauge(MetricInFlight, labels, 1)
+			start := time.Now()
+			res, err := do(req)
+			elapsed := time.Since(start)
+			sink.AddGauge(MetricInFlight, labels, -1)
+
+			if err != nil {
+				sink.AddCounter(MetricErrors, labels, 1)
+			}
+			withStatus := make(map[string]string, len(labels)+1)
+			for k, v := range labels {
+				withStatus[k] = v
+			}
+			withStatus["status_class"] = statusClass(res, err)
+			sink.AddCounter(MetricRequests, withStatus, 1)
+			sink.Observe(MetricDuration, withStatus, elapsed.Seconds())
+			return res, err
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package apic_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kolach/apic"
+)
+
+var _ = Describe("WithMetrics", func() {
+	var (
+		sink   *MemoryMetricsSink
+		req    *http.Request
+		labels map[string]string
+	)
+
+	BeforeEach(func() {
+		sink = NewMemoryMetricsSink(0.1, 1)
+		req, _ = NewRequest("GET", "https://example.com/orders/1", nil, WithRouteTemplate("/orders/{id}"))
+		labels = map[string]string{"method": "GET", "host": "example.com", "route": "/orders/{id}"}
+	})
+
+	withStatus := func(class string) map[string]string {
+		m := map[string]string{"status_class": class}
+		for k, v := range labels {
+			m[k] = v
+		}
+		return m
+	}
+
+	It("should count requests by status class and observe duration", func() {
+		count := 0
+		do := WithMetrics(sink)(respondStatus(http.StatusOK, &count))
+		do(req)
+		do(req)
+
+		Ω(sink.Value(MetricRequests, withStatus("2xx"))).Should(Equal(2.0))
+		Ω(sink.Value(MetricInFlight, labels)).Should(Equal(0.0))
+		h := sink.Histogram(MetricDuration, withStatus("2xx"))
+		Ω(h.Count).Should(Equal(uint64(2)))
+		Ω(h.Buckets).Should(Equal([]uint64{2, 2}))
+	})
+
+	It("should count errors and retries", func() {
+		count := 0
+		req, _ = NewRequest("POST", "https://example.com/orders/1", bytes.NewBufferString("test"),
+			WithRouteTemplate("/orders/{id}"))
+		labels["method"] = "POST"
+		b := backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Millisecond), 2)
+		WithRetry(b)(WithMetrics(sink)(failWith(fmt.Errorf("Error"), &count)))(req)
+
+		Ω(sink.Value(MetricRequests, withStatus("error"))).Should(Equal(3.0))
+		Ω(sink.Value(MetricErrors, labels)).Should(Equal(3.0))
+		Ω(sink.Value(MetricRetries, labels)).Should(Equal(2.0))
+	})
+
+	It("should label requests without route template as other", func() {
+		req, _ = http.NewRequest("GET", "https://example.com/orders/1", nil)
+		count := 0
+		WithMetrics(sink)(respondStatus(http.StatusNotFound, &count))(req)
+		labels["route"] = "other"
+		Ω(sink.Value(MetricRequests, withStatus("4xx"))).Should(Equal(1.0))
+	})
+})
+
+var _ = Describe("MemoryMetricsSink", func() {
+	It("should write metrics in Prometheus text format", func() {
+		sink := NewMemoryMetricsSink(0.5)
+		sink.AddCounter("requests_total", map[string]string{"path": `a"b`}, 2)
+		sink.AddGauge("in_flight", nil, 1)
+		sink.Observe("duration_seconds", map[string]string{"method": "GET"}, 0.2)
+		sink.Observe("duration_seconds", map[string]string{"method": "GET"}, 0.7)
+
+		var b bytes.Buffer
+		Ω(sink.WritePrometheus(&b)).Should(Succeed())
+		Ω(b.String()).Should(Equal(`# TYPE duration_seconds histogram
+duration_seconds_bucket{method="GET",le="0.5"} 1
+duration_seconds_bucket{method="GET",le="+Inf"} 2
+duration_seconds_sum{method="GET"} 0.8999999999999999
+duration_seconds_count{method="GET"} 2
+# TYPE in_flight gauge
+in_flight 1
+# TYPE requests_total counter
+requests_total{path="a\"b"} 2
+`))
+	})
+})
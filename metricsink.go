@@ -0,0 +1,231 @@
+package apic
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram buckets in seconds, the same Prometheus clients use
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metric kinds
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+)
+
+// HistogramSnapshot is state of histogram series
+type HistogramSnapshot struct {
+	Count   uint64
+	Sum     float64
+	Buckets []uint64 // cumulative counts of samples less or equal to bucket bounds
+}
+
+// series is single labeled metric
+type series struct {
+	labels    map[string]string
+	value     float64
+	histogram *HistogramSnapshot
+}
+
+// family is metric with all its series
+type family struct {
+	kind   string
+	series map[string]*series
+}
+
+// MemoryMetricsSink keeps metrics in memory and exposes them in Prometheus text format
+type MemoryMetricsSink struct {
+	mu       sync.Mutex
+	buckets  []float64
+	families map[string]*family
+}
+
+// NewMemoryMetricsSink constructs in-memory sink with histogram buckets,
+// DefaultBuckets are used if none given
+func NewMemoryMetricsSink(buckets ...float64) *MemoryMetricsSink {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &MemoryMetricsSink{buckets: buckets, families: make(map[string]*family)}
+}
+
+// labelsKey is stable key of label set
+func labelsKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[name]))
+	}
+	return b.String()
+}
+
+// get returns series creating it if needed, must be called under lock
+func (s *MemoryMetricsSink) get(kind, name string, labels map[string]string) *series {
+	f, ok := s.families[name]
+	if !ok {
+		f = &family{kind: kind, series: make(map[string]*series)}
+		s.families[name] = f
+	}
+	key := labelsKey(labels)
+	ser, ok := f.series[key]
+	if !ok {
+		copied := make(map[string]string, len(labels))
+		for k, v := range labels {
+			copied[k] = v
+		}
+		ser = &series{labels: copied}
+		if kind == kindHistogram {
+			ser.histogram = &HistogramSnapshot{Buckets: make([]uint64, len(s.buckets))}
+		}
+		f.series[key] = ser
+	}
+	return ser
+}
+
+// AddCounter adds delta to counter
+func (s *MemoryMetricsSink) AddCounter(name string, labels map[string]string, delta float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.get(kindCounter, name, labels).value += delta
+}
+
+// AddGauge adds delta to gauge
+func (s *MemoryMetricsSink) AddGauge(name string, labels map[string]string, delta float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.get(kindGauge, name, labels).value += delta
+}
+
+// Observe records histogram sample
+func (s *MemoryMetricsSink) Observe(name string, labels map[string]string, value float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := s.get(kindHistogram, name, labels).histogram
+	h.Count++
+	h.Sum += value
+	for i, bound := range s.buckets {
+		if value <= bound {
+			h.Buckets[i]++
+		}
+	}
+}
+
+// Value returns value of counter or gauge
+func (s *MemoryMetricsSink) Value(name string, labels map[string]string) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.families[name]; ok {
+		if ser, ok := f.series[labelsKey(labels)]; ok {
+			return ser.value
+		}
+	}
+	return 0
+}
+
+// Histogram returns snapshot of histogram
+func (s *MemoryMetricsSink) Histogram(name string, labels map[string]string) HistogramSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.families[name]; ok {
+		if ser, ok := f.series[labelsKey(labels)]; ok && ser.histogram != nil {
+			h := *ser.histogram
+			h.Buckets = append([]uint64(nil), h.Buckets...)
+			return h
+		}
+	}
+	return HistogramSnapshot{}
+}
+
+// formatLabels formats label set in Prometheus text format, with extra label appended
+func formatLabels(labels map[string]string, extra ...string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names)+1)
+	for _, name := range names {
+		parts = append(parts, name+`="`+escapeLabel(labels[name])+`"`)
+	}
+	if len(extra) == 2 {
+		parts = append(parts, extra[0]+`="`+escapeLabel(extra[1])+`"`)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// escapeLabel escapes label value as Prometheus text format requires
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// formatFloat formats sample value as Prometheus text format requires
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// WritePrometheus writes metrics in Prometheus text exposition format
+func (s *MemoryMetricsSink) WritePrometheus(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	names := make([]string, 0, len(s.families))
+	for name := range s.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := s.families[name]
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, f.kind)
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			ser := f.series[key]
+			if ser.histogram == nil {
+				fmt.Fprintf(bw, "%s%s %s\n", name, formatLabels(ser.labels), formatFloat(ser.value))
+				continue
+			}
+			h := ser.histogram
+			for i, bound := range s.buckets {
+				fmt.Fprintf(bw, "%s_bucket%s %d\n", name, formatLabels(ser.labels, "le", formatFloat(bound)), h.Buckets[i])
+			}
+			fmt.Fprintf(bw, "%s_bucket%s %d\n", name, formatLabels(ser.labels, "le", "+Inf"), h.Count)
+			fmt.Fprintf(bw, "%s_sum%s %s\n", name, formatLabels(ser.labels), formatFloat(h.Sum))
+			fmt.Fprintf(bw, "%s_count%s %d\n", name, formatLabels(ser.labels), h.Count)
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,146 @@
+package apic
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Progress describes the state of request or response body transfer
+type Progress struct {
+	Transferred int64         // bytes transferred so far
+	Total       int64         // total bytes expected, -1 if unknown
+	Elapsed     time.Duration // time passed since transfer started
+	Done        bool          // true when body has been read till EOF
+}
+
+// Rate returns transfer throughput in bytes per second
+func (p Progress) Rate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Transferred) / p.Elapsed.Seconds()
+}
+
+// ProgressFunc is callback function type to receive body transfer progress
+type ProgressFunc func(Progress)
+
+// progressReader wraps body and reports every read to callback
+type progressReader struct {
+	mu          sync.Mutex
+	body        io.ReadCloser
+	total       int64
+	transferred int64
+	started     time.Time
+	report      ProgressFunc
+}
+
+// newProgressReader constructs progressReader from given body
+func newProgressReader(body io.ReadCloser, total int64, report ProgressFunc) *progressReader {
+	if total <= 0 {
+		total = -1
+	}
+	return &progressReader{body: body, total: total, report: report}
+}
+
+// Read reads from underlying body and reports progress
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+
+	r.mu.Lock()
+	if r.started.IsZero() {
+		r.started = time.Now()
+	}
+	r.transferred += int64(n)
+	progress := Progress{
+		Transferred: r.transferred,
+		Total:       r.total,
+		Elapsed:     time.Since(r.started),
+		Done:        err == io.EOF,
+	}
+	r.mu.Unlock()
+
+	if n > 0 || err == io.EOF {
+		r.report(progress)
+	}
+	return n, err
+}
+
+// Close closes underlying body
+func (r *progressReader) Close() error {
+	return r.body.Close()
+}
+
+// progressReadSeeker is progressReader over seek-able body
+type progressReadSeeker struct {
+	*progressReader
+}
+
+// Seek rewinds underlying body and resets transfer counters,
+// so retry attempts report progress from the start.
+func (r *progressReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.body.(io.Seeker).Seek(offset, whence)
+	if err != nil {
+		return pos, err
+	}
+
+	r.mu.Lock()
+	r.transferred = pos
+	r.started = time.Time{}
+	r.mu.Unlock()
+	return pos, nil
+}
+
+// newProgressBody wraps body with progress reporting reader,
+// keeping it seek-able if the original body is.
+func newProgressBody(body io.ReadCloser, total int64, report ProgressFunc) io.ReadCloser {
+	r := newProgressReader(body, total, report)
+	if _, ok := body.(io.Seeker); ok {
+		return &progressReadSeeker{r}
+	}
+	return r
+}
+
+// WithUploadProgress reports request body upload progress to callback.
+// Usage example:
+//
+// res, err := c.Do(req, WithUploadProgress(func(p Progress) {
+// 	log.Printf("uploaded %d of %d bytes, %.0f B/s", p.Transferred, p.Total, p.Rate())
+// }))
+//
+func WithUploadProgress(fn ProgressFunc) InterceptDoFunc {
+	return func(do DoFunc) DoFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			if req.Body == nil || req.Body == http.NoBody {
+				return do(req)
+			}
+			// copy request so retry interceptor keeps seeking the original body
+			r := cloneRequest(req)
+			r.Body = newProgressBody(req.Body, req.ContentLength, fn)
+			return do(r)
+		}
+	}
+}
+
+// WithDownloadProgress reports response body download progress to callback.
+// Total is taken from response Content-Length.
+func WithDownloadProgress(fn ProgressFunc) InterceptDoFunc {
+	return func(do DoFunc) DoFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			res, err := do(req)
+			if err != nil || res == nil || res.Body == nil {
+				return res, err
+			}
+			res.Body = newProgressBody(res.Body, res.ContentLength, fn)
+			return res, nil
+		}
+	}
+}
+
+// cloneRequest makes shallow copy of request
+func cloneRequest(req *http.Request) *http.Request {
+	r := new(http.Request)
+	*r = *req
+	return r
+}
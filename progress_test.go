@@ -0,0 +1,129 @@
+package apic_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kolach/apic"
+)
+
+var _ = Describe("Progress", func() {
+	var reports []Progress
+
+	record := func(p Progress) { reports = append(reports, p) }
+
+	BeforeEach(func() {
+		reports = nil
+	})
+
+	Describe("Rate", func() {
+		It("should return bytes per second", func() {
+			p := Progress{Transferred: 100, Elapsed: 2 * time.Second}
+			Ω(p.Rate()).Should(Equal(50.0))
+		})
+
+		It("should return zero when nothing elapsed", func() {
+			Ω(Progress{Transferred: 100}.Rate()).Should(BeZero())
+		})
+	})
+
+	Describe("WithUploadProgress", func() {
+		It("should report request body transfer", func() {
+			req, _ := http.NewRequest("POST", "https://example.com", bytes.NewBufferString("Buy iPhoneX"))
+			_, err := WithUploadProgress(record)(func(req *http.Request) (*http.Response, error) {
+				_, err := ioutil.ReadAll(req.Body)
+				return new(http.Response), err
+			})(req)
+
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(reports).ShouldNot(BeEmpty())
+			last := reports[len(reports)-1]
+			Ω(last.Transferred).Should(Equal(int64(11)))
+			Ω(last.Total).Should(Equal(int64(11)))
+			Ω(last.Done).Should(BeTrue())
+		})
+
+		It("should restart counting on every retry attempt", func() {
+			var count int
+			b := backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Millisecond), 2)
+			req, _ := http.NewRequest("POST", "https://example.com", bytes.NewBufferString("Buy iPhoneX"))
+			do := WithUploadProgress(record)(failWith(fmt.Errorf("Error"), &count))
+			_, err := WithRetry(b)(do)(req)
+
+			Ω(err).Should(MatchError("Error"))
+			Ω(count).Should(Equal(3))
+			for _, p := range reports {
+				Ω(p.Transferred).Should(BeNumerically("<=", 11))
+			}
+			var done int
+			for _, p := range reports {
+				if p.Done {
+					done++
+					Ω(p.Transferred).Should(Equal(int64(11)))
+				}
+			}
+			Ω(done).Should(Equal(3))
+		})
+
+		It("should reset counters when body is rewound", func() {
+			var count int
+			b := backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Millisecond), 1)
+			req, _ := http.NewRequest("POST", "https://example.com", nil)
+			req.Body = &seekableBody{bytes.NewReader([]byte("foo"))}
+			req.ContentLength = 3
+			_, err := WithUploadProgress(record)(WithRetry(b)(failWith(fmt.Errorf("Error"), &count)))(req)
+
+			Ω(err).Should(MatchError("Error"))
+			Ω(count).Should(Equal(2))
+			for _, p := range reports {
+				Ω(p.Transferred).Should(BeNumerically("<=", 3))
+			}
+		})
+	})
+
+	Describe("WithDownloadProgress", func() {
+		It("should report response body transfer", func() {
+			req, _ := http.NewRequest("GET", "https://example.com", nil)
+			res, err := WithDownloadProgress(record)(func(req *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode:    200,
+					ContentLength: 4,
+					Body:          ioutil.NopCloser(bytes.NewBufferString("test")),
+				}, nil
+			})(req)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			b, _ := ioutil.ReadAll(res.Body)
+			Ω(b).Should(Equal([]byte("test")))
+			last := reports[len(reports)-1]
+			Ω(last.Transferred).Should(Equal(int64(4)))
+			Ω(last.Total).Should(Equal(int64(4)))
+			Ω(last.Done).Should(BeTrue())
+		})
+
+		It("should report unknown total as -1", func() {
+			req, _ := http.NewRequest("GET", "https://example.com", nil)
+			res, _ := WithDownloadProgress(record)(func(req *http.Request) (*http.Response, error) {
+				return &http.Response{
+					ContentLength: -1,
+					Body:          ioutil.NopCloser(bytes.NewBufferString("test")),
+				}, nil
+			})(req)
+			ioutil.ReadAll(res.Body)
+			Ω(reports[0].Total).Should(Equal(int64(-1)))
+		})
+	})
+})
+
+type seekableBody struct {
+	*bytes.Reader
+}
+
+func (r *seekableBody) Close() error { return nil }
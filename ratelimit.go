@@ -0,0 +1,234 @@
+package apic
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrRateLimited is returned when waiting for rate limit would exceed request context deadline
+var ErrRateLimited = errors.New("rate limit wait exceeds context deadline")
+
+// RouteKey scopes state per request method, host and path
+func RouteKey(req *http.Request) string {
+	return req.Method + " " + req.URL.Host + req.URL.Path
+}
+
+// HeaderKey scopes state per value of given request header, like API key
+func HeaderKey(name string) KeyFunc {
+	return func(req *http.Request) string {
+		return req.Header.Get(name)
+	}
+}
+
+// bucket is token bucket of a single key
+type bucket struct {
+	tokens   float64   // available tokens, negative when reserved in advance
+	last     time.Time // last time tokens were refilled
+	override float64   // rate advertised by server
+	until    time.Time // time override rate is in effect until
+}
+
+// RateLimiter is token bucket rate limiter with per key buckets
+type RateLimiter struct {
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	rate     float64 // tokens per second
+	burst    float64 // bucket capacity
+	key      KeyFunc
+	adaptive bool
+}
+
+// RateLimitOptionFunc is functional type to configure rate limiter
+type RateLimitOptionFunc func(l *RateLimiter)
+
+// WithRateLimitKey sets function to scope buckets, default is per host
+func WithRateLimitKey(key KeyFunc) RateLimitOptionFunc {
+	return func(l *RateLimiter) {
+		l.key = key
+	}
+}
+
+// WithAdaptiveRate makes limiter follow X-RateLimit-Remaining and X-RateLimit-Reset
+// response headers: until reset time the rate is slowed down to spread remaining quota.
+func WithAdaptiveRate() RateLimitOptionFunc {
+	return func(l *RateLimiter) {
+		l.adaptive = true
+	}
+}
+
+// NewRateLimiter constructs rate limiter allowing rate requests per second
+// with bursts of up to burst requests.
+func NewRateLimiter(rate float64, burst int, opts ...RateLimitOptionFunc) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	l := &RateLimiter{
+		buckets: make(map[string]*bucket),
+		rate:    rate,
+		burst:   float64(burst),
+		key:     HostKey,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// bucket returns bucket of given key, must be called under lock
+func (l *RateLimiter) bucket(key string, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	return b
+}
+
+// refill adds tokens accumulated since last refill, must be called under lock
+func (l *RateLimiter) refill(b *bucket, now time.Time) {
+	if !now.After(b.last) {
+		return
+	}
+	from := b.last
+	if from.Before(b.until) {
+		to := b.until
+		if now.Before(to) {
+			to = now
+		}
+		b.tokens += b.override * to.Sub(from).Seconds()
+		from = to
+	}
+	if now.After(from) {
+		b.tokens += l.rate * now.Sub(from).Seconds()
+	}
+	b.tokens = math.Min(b.tokens, l.burst)
+	b.last = now
+}
+
+// delay returns how long to wait for deficit tokens to be refilled, must be called under lock
+func (l *RateLimiter) delay(b *bucket, deficit float64, now time.Time) time.Duration {
+	var d time.Duration
+	if now.Before(b.until) {
+		window := b.until.Sub(now)
+		gain := b.override * window.Seconds()
+		if gain >= deficit {
+			return time.Duration(deficit / b.override * float64(time.Second))
+		}
+		deficit -= gain
+		d = window
+	}
+	if l.rate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return d + time.Duration(deficit/l.rate*float64(time.Second))
+}
+
+// reserve takes a token and returns how long to wait till it is available
+func (l *RateLimiter) reserve(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.bucket(key, now)
+	l.refill(b, now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return l.delay(b, -b.tokens, now)
+}
+
+// cancel returns reserved token
+func (l *RateLimiter) cancel(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buckets[key].tokens++
+}
+
+// Wait blocks until request with given key is allowed or context is done
+func (l *RateLimiter) Wait(ctx context.Context, key string) error {
+	now := time.Now()
+	d := l.reserve(key, now)
+	if d == 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(now.Add(d)) {
+		l.cancel(key)
+		return ErrRateLimited
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		l.cancel(key)
+		return errors.Wrap(ctx.Err(), "rate limit wait canceled")
+	case <-t.C:
+		return nil
+	}
+}
+
+// Update adapts rate of given key to remaining quota till reset time
+func (l *RateLimiter) Update(key string, remaining int, reset time.Time) {
+	now := time.Now()
+	if !reset.After(now) {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.bucket(key, now)
+	l.refill(b, now)
+	b.tokens = math.Min(b.tokens, float64(remaining))
+	b.override = float64(remaining) / reset.Sub(now).Seconds()
+	b.until = reset
+}
+
+// parseRateLimit reads quota from X-RateLimit-Remaining and X-RateLimit-Reset headers.
+// Reset is accepted both as unix time and as seconds from now.
+func parseRateLimit(h http.Header, now time.Time) (int, time.Time, bool) {
+	remaining, err := strconv.Atoi(strings.TrimSpace(h.Get("X-RateLimit-Remaining")))
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+	reset, err := strconv.ParseFloat(strings.TrimSpace(h.Get("X-RateLimit-Reset")), 64)
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+	if reset > 1e9 {
+		return remaining, time.Unix(0, int64(reset*float64(time.Second))), true
+	}
+	return remaining, now.Add(time.Duration(reset * float64(time.Second))), true
+}
+
+// WithRateLimit delays requests to comply with rate limiter, the wait is bound
+// to request context.
+// Usage example:
+//
+// l := NewRateLimiter(10, 5, WithRateLimitKey(HeaderKey("X-Api-Key")), WithAdaptiveRate())
+// ...
+// res, err := c.Do(req, WithRateLimit(l))
+//
+func WithRateLimit(l *RateLimiter) InterceptDoFunc {
+	return func(do DoFunc) DoFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			key := l.key(req)
+			if err := l.Wait(req.Context(), key); err != nil {
+				return nil, err
+			}
+
+			res, err := do(req)
+			if l.adaptive && res != nil {
+				if remaining, reset, ok := parseRateLimit(res.Header, time.Now()); ok {
+					l.Update(key, remaining, reset)
+				}
+			}
+			return res, err
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package apic_test
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+
+	. "github.com/kolach/apic"
+)
+
+var _ = Describe("RateLimiter", func() {
+	var (
+		req   *http.Request
+		count int
+	)
+
+	BeforeEach(func() {
+		count = 0
+		req, _ = http.NewRequest("GET", "https://example.com/orders/1", nil)
+	})
+
+	It("should let burst requests pass immediately", func() {
+		do := WithRateLimit(NewRateLimiter(1, 3))(respondStatus(http.StatusOK, &count))
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			do(req)
+		}
+		Ω(time.Since(start)).Should(BeNumerically("<", 50*time.Millisecond))
+		Ω(count).Should(Equal(3))
+	})
+
+	It("should delay requests beyond burst", func() {
+		do := WithRateLimit(NewRateLimiter(20, 1))(respondStatus(http.StatusOK, &count))
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			do(req)
+		}
+		Ω(time.Since(start)).Should(BeNumerically(">=", 90*time.Millisecond))
+	})
+
+	It("should scope buckets by key", func() {
+		l := NewRateLimiter(0.1, 1, WithRateLimitKey(HeaderKey("X-Api-Key")))
+		do := WithRateLimit(l)(respondStatus(http.StatusOK, &count))
+
+		for _, key := range []string{"a", "b", "c"} {
+			r, _ := http.NewRequest("GET", "https://example.com/orders/1", nil)
+			r.Header.Set("X-Api-Key", key)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			_, err := do(r.WithContext(ctx))
+			cancel()
+			Ω(err).ShouldNot(HaveOccurred())
+		}
+	})
+
+	It("should fail fast if wait exceeds context deadline", func() {
+		do := WithRateLimit(NewRateLimiter(0.1, 1))(respondStatus(http.StatusOK, &count))
+		do(req)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err := do(req.WithContext(ctx))
+		Ω(err).Should(Equal(ErrRateLimited))
+		Ω(count).Should(Equal(1))
+	})
+
+	It("should stop waiting when context is canceled", func() {
+		do := WithRateLimit(NewRateLimiter(0.1, 1))(respondStatus(http.StatusOK, &count))
+		do(req)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		_, err := do(req.WithContext(ctx))
+		Ω(errors.Cause(err)).Should(Equal(context.Canceled))
+		Ω(count).Should(Equal(1))
+	})
+
+	It("should adapt rate to response headers", func() {
+		l := NewRateLimiter(1000, 10, WithAdaptiveRate())
+		do := WithRateLimit(l)(func(req *http.Request) (*http.Response, error) {
+			count++
+			res := &http.Response{StatusCode: http.StatusOK, Header: make(http.Header)}
+			res.Header.Set("X-RateLimit-Remaining", "0")
+			res.Header.Set("X-RateLimit-Reset", "60")
+			return res, nil
+		})
+		do(req)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err := do(req.WithContext(ctx))
+		Ω(err).Should(Equal(ErrRateLimited))
+		Ω(count).Should(Equal(1))
+	})
+
+	It("should accept reset as unix time", func() {
+		l := NewRateLimiter(1000, 10, WithAdaptiveRate())
+		do := WithRateLimit(l)(func(req *http.Request) (*http.Response, error) {
+			res := &http.Response{StatusCode: http.StatusOK, Header: make(http.Header)}
+			res.Header.Set("X-RateLimit-Remaining", "0")
+			res.Header.Set("X-RateLimit-Reset", "4102444800") // 2100-01-01
+			return res, nil
+		})
+		do(req)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err := do(req.WithContext(ctx))
+		Ω(err).Should(Equal(ErrRateLimited))
+	})
+})
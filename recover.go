@@ -0,0 +1,50 @@
+package apic
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+// PanicError is a panic recovered from do function chain
+type PanicError struct {
+	Value interface{} // value passed to panic
+	Stack []byte      // stack trace of panicked goroutine
+}
+
+func (err *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", err.Value)
+}
+
+// newPanicError constructs PanicError capturing current stack,
+// must be called from deferred function
+func newPanicError(v interface{}) *PanicError {
+	return &PanicError{Value: v, Stack: debug.Stack()}
+}
+
+// recoverKey is context key marking requests run under WithRecover
+type recoverKey struct{}
+
+// recovering tells if panics of request are recovered by WithRecover
+func recovering(ctx context.Context) bool {
+	return ctx.Value(recoverKey{}) != nil
+}
+
+// WithRecover converts panic in intercepted do function chain into *PanicError.
+// Panics are not recovered anywhere else, so install it as the outermost
+// interceptor to cover the whole chain (see WithPanicRecovery client option).
+// Panics in goroutines of WithHedging reach it as well, without it they are
+// re-raised on the caller goroutine. PanicError is never retried.
+func WithRecover() InterceptDoFunc {
+	return func(do DoFunc) DoFunc {
+		return func(req *http.Request) (res *http.Response, err error) {
+			defer func() {
+				if v := recover(); v != nil {
+					res, err = nil, newPanicError(v)
+				}
+			}()
+			return do(req.WithContext(context.WithValue(req.Context(), recoverKey{}, true)))
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package apic_test
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+
+	. "github.com/kolach/apic"
+)
+
+// panicWith returns do function panicking with given value
+func panicWith(v interface{}, count *int) DoFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		*count++
+		panic(v)
+	}
+}
+
+var _ = Describe("WithRecover", func() {
+	var (
+		req   *http.Request
+		count int
+	)
+
+	BeforeEach(func() {
+		count = 0
+		req, _ = http.NewRequest("GET", "https://example.com/orders/1", nil)
+	})
+
+	It("should convert panic with non-error value into PanicError", func() {
+		res, err := WithRecover()(panicWith("boom", &count))(req)
+		Ω(res).Should(BeNil())
+		panicErr, ok := err.(*PanicError)
+		Ω(ok).Should(BeTrue())
+		Ω(panicErr.Value).Should(Equal("boom"))
+		Ω(string(panicErr.Stack)).Should(ContainSubstring("panicWith"))
+		Ω(err).Should(MatchError("panic: boom"))
+	})
+
+	It("should convert panic with error value into PanicError", func() {
+		_, err := WithRecover()(panicWith(fmt.Errorf("Error"), &count))(req)
+		Ω(err).Should(MatchError("panic: Error"))
+	})
+
+	It("should let panic through retry interceptor", func() {
+		b := backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Millisecond), 3)
+		Ω(func() { WithRetry(b)(panicWith("boom", &count))(req) }).Should(Panic())
+	})
+
+	It("should not retry recovered panic", func() {
+		b := backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Millisecond), 3)
+		_, err := WithRetry(b)(WithRecover()(panicWith("boom", &count)))(req)
+		_, ok := errors.Cause(err).(*PanicError)
+		Ω(ok).Should(BeTrue())
+		Ω(count).Should(Equal(1))
+	})
+
+	It("should cover the whole client interceptors chain", func() {
+		client := NewClient(WithConstantBackOff(time.Millisecond), WithMaxRetries(3), WithPanicRecovery())
+		_, err := client.Do(req, func(DoFunc) DoFunc { return panicWith(42, &count) })
+		Ω(err).Should(MatchError("panic: 42"))
+		Ω(count).Should(Equal(1))
+	})
+})
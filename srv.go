@@ -0,0 +1,195 @@
+package apic
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SRVResolver looks up SRV records, *net.Resolver implements it
+type SRVResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// SRVEndpoints discovers endpoints published with DNS SRV record.
+// Looked up records are kept for TTL, failed refresh keeps serving previous ones.
+type SRVEndpoints struct {
+	service  string
+	proto    string
+	name     string
+	resolver SRVResolver
+	scheme   string
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	records []*net.SRV
+	byURL   map[string]*net.SRV // records by endpoint URL
+	expires time.Time
+}
+
+// SRVOptionFunc is functional type to configure SRV endpoints
+type SRVOptionFunc func(s *SRVEndpoints)
+
+// WithSRVResolver sets resolver to look up records, default is net.DefaultResolver
+func WithSRVResolver(r SRVResolver) SRVOptionFunc {
+	return func(s *SRVEndpoints) {
+		s.resolver = r
+	}
+}
+
+// WithSRVScheme sets scheme of endpoint URLs, default is https
+func WithSRVScheme(scheme string) SRVOptionFunc {
+	return func(s *SRVEndpoints) {
+		s.scheme = scheme
+	}
+}
+
+// WithSRVTTL sets how long looked up records are used before refresh, default is 30 seconds.
+// Go resolver doesn't expose record TTL, so set it to match the published one.
+func WithSRVTTL(d time.Duration) SRVOptionFunc {
+	return func(s *SRVEndpoints) {
+		s.ttl = d
+	}
+}
+
+// NewSRVEndpoints constructs SRV endpoints for record in _service._proto.name form
+func NewSRVEndpoints(record string, opts ...SRVOptionFunc) (*SRVEndpoints, error) {
+	parts := strings.SplitN(record, ".", 3)
+	if len(parts) != 3 || !strings.HasPrefix(parts[0], "_") || !strings.HasPrefix(parts[1], "_") || parts[2] == "" {
+		return nil, errors.Errorf("invalid SRV record %q, expected _service._proto.name", record)
+	}
+	s := &SRVEndpoints{
+		service:  strings.TrimPrefix(parts[0], "_"),
+		proto:    strings.TrimPrefix(parts[1], "_"),
+		name:     parts[2],
+		resolver: net.DefaultResolver,
+		scheme:   "https",
+		ttl:      30 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
+}
+
+// lookup returns records refreshing them once TTL is over
+func (s *SRVEndpoints) lookup(ctx context.Context) ([]*net.SRV, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.records != nil && time.Now().Before(s.expires) {
+		return s.records, nil
+	}
+	_, records, err := s.resolver.LookupSRV(ctx, s.service, s.proto, s.name)
+	if err != nil {
+		if s.records != nil {
+			return s.records, nil
+		}
+		return nil, errors.Wrap(err, "failed to look up SRV record")
+	}
+	s.records, s.expires = records, time.Now().Add(s.ttl)
+	s.byURL = make(map[string]*net.SRV, len(records))
+	for _, r := range records {
+		s.byURL[s.url(r)] = r
+	}
+	return records, nil
+}
+
+// url returns endpoint URL of record
+func (s *SRVEndpoints) url(r *net.SRV) string {
+	u := url.URL{Scheme: s.scheme, Host: net.JoinHostPort(strings.TrimSuffix(r.Target, "."), fmt.Sprint(r.Port))}
+	return u.String()
+}
+
+// available returns available records ordered by priority and then by weight,
+// the order is stable till records are refreshed
+func (s *SRVEndpoints) available(ctx context.Context) ([]*net.SRV, error) {
+	records, err := s.lookup(ctx)
+	if err != nil {
+		return nil, err
+	}
+	available := make([]*net.SRV, 0, len(records))
+	for _, r := range records {
+		// target "." means service is decidedly not available
+		if r.Target != "." && r.Target != "" {
+			available = append(available, r)
+		}
+	}
+	if len(available) == 0 {
+		return nil, ErrNoEndpoints
+	}
+	sort.SliceStable(available, func(i, j int) bool {
+		if available[i].Priority != available[j].Priority {
+			return available[i].Priority < available[j].Priority
+		}
+		return available[i].Weight > available[j].Weight
+	})
+	return available, nil
+}
+
+// Endpoints returns endpoint URLs of all priorities, most preferred first.
+// Balancer doesn't know SRV priorities and weights from the list alone,
+// so pass them with WithEndpointRank along:
+//
+// b := NewBalancer(s.Endpoints, WithEndpointRank(s.Rank))
+//
+func (s *SRVEndpoints) Endpoints() ([]string, error) {
+	records, err := s.available(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	urls := make([]string, len(records))
+	for i, r := range records {
+		urls[i] = s.url(r)
+	}
+	return urls, nil
+}
+
+// Rank returns SRV priority and weight of endpoint URL returned by Endpoints.
+// Unknown endpoints get the least preferred priority.
+func (s *SRVEndpoints) Rank(u string) (priority, weight int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.byURL[u]
+	if !ok {
+		return math.MaxUint16 + 1, 0
+	}
+	return int(r.Priority), int(r.Weight)
+}
+
+// WithSRVBaseURL assigns host and scheme of endpoint picked from SRV record to request URL,
+// the way WithBaseURL does with fixed one.
+// Usage example:
+//
+// s, err := NewSRVEndpoints("_orders._tcp.example.com")
+// ...
+// NewRequest := NewRequestFactory(WithSRVBaseURL(s))
+// ...
+// req, err = NewRequest("GET", "/orders", nil)
+//
+func WithSRVBaseURL(s *SRVEndpoints) RequestOptionFunc {
+	return func(req *http.Request) (*http.Request, error) {
+		records, err := s.available(req.Context())
+		if err != nil {
+			return nil, err
+		}
+		// pick among the best priority with probability proportional to weight, as RFC 2782 prescribes
+		best := records[:1]
+		for len(best) < len(records) && records[len(best)].Priority == records[0].Priority {
+			best = records[:len(best)+1]
+		}
+		weights := make([]int, len(best))
+		for i, r := range best {
+			weights[i] = int(r.Weight)
+		}
+		return WithBaseURL(s.url(best[weightedIndex(weights)]))(req)
+	}
+}
@@ -0,0 +1,128 @@
+package apic_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kolach/apic"
+)
+
+// fakeSRVResolver returns configured records
+type fakeSRVResolver struct {
+	records []*net.SRV
+	err     error
+	calls   int
+	query   string
+}
+
+func (r *fakeSRVResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.calls++
+	r.query = fmt.Sprintf("%s %s %s", service, proto, name)
+	return "", r.records, r.err
+}
+
+var _ = Describe("SRVEndpoints", func() {
+	var r *fakeSRVResolver
+
+	BeforeEach(func() {
+		r = &fakeSRVResolver{records: []*net.SRV{
+			{Target: "backup.example.com.", Port: 8443, Priority: 20, Weight: 1},
+			{Target: "a.example.com.", Port: 443, Priority: 10, Weight: 0},
+			{Target: "b.example.com.", Port: 443, Priority: 10, Weight: 100},
+		}}
+	})
+
+	It("should reject malformed record", func() {
+		_, err := NewSRVEndpoints("orders.example.com")
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("should order endpoints by priority and weight", func() {
+		s, err := NewSRVEndpoints("_orders._tcp.example.com", WithSRVResolver(r))
+		Ω(err).ShouldNot(HaveOccurred())
+
+		urls, err := s.Endpoints()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(r.query).Should(Equal("orders tcp example.com"))
+		Ω(urls).Should(Equal([]string{
+			"https://b.example.com:443",
+			"https://a.example.com:443",
+			"https://backup.example.com:8443",
+		}))
+	})
+
+	It("should refresh records after TTL and keep old ones on failure", func() {
+		s, _ := NewSRVEndpoints("_orders._tcp.example.com", WithSRVResolver(r), WithSRVTTL(time.Millisecond))
+		s.Endpoints()
+		s.Endpoints()
+		Ω(r.calls).Should(Equal(1))
+
+		time.Sleep(2 * time.Millisecond)
+		r.err = fmt.Errorf("Error")
+		urls, err := s.Endpoints()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(urls).Should(HaveLen(3))
+		Ω(r.calls).Should(Equal(2))
+	})
+
+	It("should fail on lookup error without records", func() {
+		r.err = fmt.Errorf("Error")
+		s, _ := NewSRVEndpoints("_orders._tcp.example.com", WithSRVResolver(r))
+		_, err := s.Endpoints()
+		Ω(err).Should(MatchError("failed to look up SRV record: Error"))
+	})
+
+	It("should provide base URL to request factory", func() {
+		s, _ := NewSRVEndpoints("_orders._tcp.example.com", WithSRVResolver(r), WithSRVScheme("http"))
+		newRequest := NewRequestFactory(WithSRVBaseURL(s))
+		req, err := newRequest("GET", "/orders", nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(req.URL.String()).Should(Equal("http://b.example.com:443/orders"))
+	})
+
+	It("should balance among the best priority and fail over to backup", func() {
+		r.records = append(r.records, &net.SRV{Target: "c.example.com.", Port: 443, Priority: 10, Weight: 100})
+		s, _ := NewSRVEndpoints("_orders._tcp.example.com", WithSRVResolver(r))
+		b := NewBalancer(s.Endpoints, WithEndpointRank(s.Rank), WithEndpointCooldown(time.Minute))
+
+		var hosts []string
+		down := make(map[string]bool)
+		do := WithBalancer(b)(func(req *http.Request) (*http.Response, error) {
+			hosts = append(hosts, req.URL.Hostname())
+			status := http.StatusOK
+			if down[req.URL.Hostname()] {
+				status = http.StatusServiceUnavailable
+			}
+			return &http.Response{StatusCode: status, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+		})
+		call := func() {
+			req, _ := http.NewRequest("GET", "/orders", nil)
+			res, err := do(req)
+			Ω(err).ShouldNot(HaveOccurred())
+			res.Body.Close()
+		}
+
+		for i := 0; i < 6; i++ {
+			call()
+		}
+		Ω(hosts).Should(Equal([]string{
+			"b.example.com", "c.example.com", "a.example.com",
+			"b.example.com", "c.example.com", "a.example.com",
+		}))
+
+		hosts = nil
+		down["a.example.com"], down["b.example.com"], down["c.example.com"] = true, true, true
+		for i := 0; i < 4; i++ {
+			call()
+		}
+		Ω(hosts).Should(Equal([]string{"b.example.com", "c.example.com", "a.example.com", "backup.example.com"}))
+	})
+})
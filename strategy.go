@@ -0,0 +1,206 @@
+package apic
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+// exponential returns initial*2^attempt capped by max
+func exponential(initial, max time.Duration, attempt uint) time.Duration {
+	if attempt > 62 || initial<<attempt>>attempt != initial || initial<<attempt > max {
+		return max
+	}
+	return initial << attempt
+}
+
+// randomDuration returns random duration in [0, d]
+func randomDuration(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// FullJitterBackOff sleeps random time between 0 and exponentially growing interval
+type FullJitterBackOff struct {
+	Initial time.Duration
+	Max     time.Duration
+	attempt uint
+}
+
+// NewFullJitterBackOff constructs FullJitterBackOff
+func NewFullJitterBackOff(initial, max time.Duration) *FullJitterBackOff {
+	return &FullJitterBackOff{Initial: initial, Max: max}
+}
+
+// Reset to initial state
+func (b *FullJitterBackOff) Reset() { b.attempt = 0 }
+
+// NextBackOff returns duration to wait before next retry
+func (b *FullJitterBackOff) NextBackOff() time.Duration {
+	d := exponential(b.Initial, b.Max, b.attempt)
+	b.attempt++
+	return randomDuration(d)
+}
+
+// EqualJitterBackOff sleeps half of exponentially growing interval plus random part of the other half
+type EqualJitterBackOff struct {
+	Initial time.Duration
+	Max     time.Duration
+	attempt uint
+}
+
+// NewEqualJitterBackOff constructs EqualJitterBackOff
+func NewEqualJitterBackOff(initial, max time.Duration) *EqualJitterBackOff {
+	return &EqualJitterBackOff{Initial: initial, Max: max}
+}
+
+// Reset to initial state
+func (b *EqualJitterBackOff) Reset() { b.attempt = 0 }
+
+// NextBackOff returns duration to wait before next retry
+func (b *EqualJitterBackOff) NextBackOff() time.Duration {
+	d := exponential(b.Initial, b.Max, b.attempt)
+	b.attempt++
+	return d/2 + randomDuration(d-d/2)
+}
+
+// DecorrelatedJitterBackOff sleeps random time between initial and 3 times previous sleep
+type DecorrelatedJitterBackOff struct {
+	Initial time.Duration
+	Max     time.Duration
+	prev    time.Duration
+}
+
+// NewDecorrelatedJitterBackOff constructs DecorrelatedJitterBackOff
+func NewDecorrelatedJitterBackOff(initial, max time.Duration) *DecorrelatedJitterBackOff {
+	return &DecorrelatedJitterBackOff{Initial: initial, Max: max, prev: initial}
+}
+
+// Reset to initial state
+func (b *DecorrelatedJitterBackOff) Reset() { b.prev = b.Initial }
+
+// NextBackOff returns duration to wait before next retry
+func (b *DecorrelatedJitterBackOff) NextBackOff() time.Duration {
+	upper := b.prev * 3
+	if upper < b.prev || upper > b.Max {
+		upper = b.Max
+	}
+	d := b.Initial
+	if upper > b.Initial {
+		d += randomDuration(upper - b.Initial)
+	}
+	b.prev = d
+	return d
+}
+
+// LinearBackOff sleeps interval growing by fixed increment
+type LinearBackOff struct {
+	Initial   time.Duration
+	Increment time.Duration
+	Max       time.Duration
+	current   time.Duration
+}
+
+// NewLinearBackOff constructs LinearBackOff
+func NewLinearBackOff(initial, increment, max time.Duration) *LinearBackOff {
+	return &LinearBackOff{Initial: initial, Increment: increment, Max: max, current: initial}
+}
+
+// Reset to initial state
+func (b *LinearBackOff) Reset() { b.current = b.Initial }
+
+// NextBackOff returns duration to wait before next retry
+func (b *LinearBackOff) NextBackOff() time.Duration {
+	d := b.current
+	if d >= b.Max {
+		return b.Max
+	}
+	b.current += b.Increment
+	return d
+}
+
+// FibonacciBackOff sleeps initial interval multiplied by Fibonacci numbers: 1, 1, 2, 3, 5...
+type FibonacciBackOff struct {
+	Initial time.Duration
+	Max     time.Duration
+	a, b    time.Duration
+}
+
+// NewFibonacciBackOff constructs FibonacciBackOff
+func NewFibonacciBackOff(initial, max time.Duration) *FibonacciBackOff {
+	b := &FibonacciBackOff{Initial: initial, Max: max}
+	b.Reset()
+	return b
+}
+
+// Reset to initial state
+func (b *FibonacciBackOff) Reset() { b.a, b.b = 0, b.Initial }
+
+// NextBackOff returns duration to wait before next retry
+func (b *FibonacciBackOff) NextBackOff() time.Duration {
+	if b.b >= b.Max {
+		return b.Max
+	}
+	d := b.b
+	b.a, b.b = b.b, b.a+b.b
+	if b.b < d {
+		// overflow
+		b.b = b.Max
+	}
+	return d
+}
+
+// ScheduleBackOff sleeps given delays one by one and stops when they are over
+type ScheduleBackOff struct {
+	Delays []time.Duration
+	next   int
+}
+
+// NewScheduleBackOff constructs ScheduleBackOff
+func NewScheduleBackOff(delays ...time.Duration) *ScheduleBackOff {
+	return &ScheduleBackOff{Delays: delays}
+}
+
+// Reset to initial state
+func (b *ScheduleBackOff) Reset() { b.next = 0 }
+
+// NextBackOff returns duration to wait before next retry
+func (b *ScheduleBackOff) NextBackOff() time.Duration {
+	if b.next >= len(b.Delays) {
+		return backoff.Stop
+	}
+	d := b.Delays[b.next]
+	b.next++
+	return d
+}
+
+// maxElapsedBackOff stops backoff once max time elapsed since reset
+type maxElapsedBackOff struct {
+	backoff.BackOff
+	max   time.Duration
+	start time.Time
+}
+
+// NewMaxElapsedTimeBackOff wraps backoff policy to stop when the total time elapsed
+// since reset plus next delay would exceed max.
+func NewMaxElapsedTimeBackOff(b backoff.BackOff, max time.Duration) backoff.BackOff {
+	return &maxElapsedBackOff{BackOff: b, max: max, start: time.Now()}
+}
+
+// Reset to initial state
+func (b *maxElapsedBackOff) Reset() {
+	b.start = time.Now()
+	b.BackOff.Reset()
+}
+
+// NextBackOff returns duration to wait before next retry
+func (b *maxElapsedBackOff) NextBackOff() time.Duration {
+	next := b.BackOff.NextBackOff()
+	if next == backoff.Stop || time.Since(b.start)+next > b.max {
+		return backoff.Stop
+	}
+	return next
+}
@@ -0,0 +1,108 @@
+package apic_test
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kolach/apic"
+)
+
+// delays collects n delays from backoff policy
+func delays(b backoff.BackOff, n int) []time.Duration {
+	var ds []time.Duration
+	for i := 0; i < n; i++ {
+		ds = append(ds, b.NextBackOff())
+	}
+	return ds
+}
+
+var _ = Describe("BackOff strategies", func() {
+	const ms = time.Millisecond
+
+	It("FullJitterBackOff should stay within exponential interval", func() {
+		b := NewFullJitterBackOff(10*ms, 50*ms)
+		for i, d := range delays(b, 10) {
+			upper := 10 * ms << uint(i)
+			if upper > 50*ms {
+				upper = 50 * ms
+			}
+			Ω(d).Should(BeNumerically(">=", 0))
+			Ω(d).Should(BeNumerically("<=", upper))
+		}
+	})
+
+	It("EqualJitterBackOff should stay within upper half of exponential interval", func() {
+		b := NewEqualJitterBackOff(10*ms, 50*ms)
+		for i, d := range delays(b, 10) {
+			upper := 10 * ms << uint(i)
+			if upper > 50*ms {
+				upper = 50 * ms
+			}
+			Ω(d).Should(BeNumerically(">=", upper/2))
+			Ω(d).Should(BeNumerically("<=", upper))
+		}
+	})
+
+	It("DecorrelatedJitterBackOff should stay between initial and 3 times previous delay", func() {
+		b := NewDecorrelatedJitterBackOff(10*ms, 100*ms)
+		prev := 10 * ms
+		for _, d := range delays(b, 20) {
+			Ω(d).Should(BeNumerically(">=", 10*ms))
+			Ω(d).Should(BeNumerically("<=", 3*prev))
+			Ω(d).Should(BeNumerically("<=", 100*ms))
+			prev = d
+		}
+	})
+
+	It("LinearBackOff should grow by increment", func() {
+		b := NewLinearBackOff(10*ms, 5*ms, 25*ms)
+		Ω(delays(b, 5)).Should(Equal([]time.Duration{10 * ms, 15 * ms, 20 * ms, 25 * ms, 25 * ms}))
+		b.Reset()
+		Ω(b.NextBackOff()).Should(Equal(10 * ms))
+	})
+
+	It("FibonacciBackOff should grow as Fibonacci sequence", func() {
+		b := NewFibonacciBackOff(10*ms, 60*ms)
+		Ω(delays(b, 7)).Should(Equal([]time.Duration{10 * ms, 10 * ms, 20 * ms, 30 * ms, 50 * ms, 60 * ms, 60 * ms}))
+		b.Reset()
+		Ω(b.NextBackOff()).Should(Equal(10 * ms))
+	})
+
+	It("ScheduleBackOff should follow schedule and stop", func() {
+		b := NewScheduleBackOff(10*ms, 30*ms)
+		Ω(delays(b, 3)).Should(Equal([]time.Duration{10 * ms, 30 * ms, backoff.Stop}))
+		b.Reset()
+		Ω(b.NextBackOff()).Should(Equal(10 * ms))
+	})
+
+	It("MaxElapsedTimeBackOff should stop when time is over", func() {
+		b := NewMaxElapsedTimeBackOff(backoff.NewConstantBackOff(10*ms), 25*ms)
+		Ω(b.NextBackOff()).Should(Equal(10 * ms))
+		time.Sleep(20 * ms)
+		Ω(b.NextBackOff()).Should(Equal(backoff.Stop))
+		b.Reset()
+		Ω(b.NextBackOff()).Should(Equal(10 * ms))
+	})
+
+	It("should plug into client with max retries", func() {
+		var count int
+		client := NewClient(
+			WithScheduleBackOff(ms, ms, ms),
+			WithMaxRetries(1),
+			WithNotify(func(error, time.Duration) { count++ }),
+		)
+		req, _ := http.NewRequest("GET", "https://example.com", nil)
+		_, err := client.Do(req, func(DoFunc) DoFunc {
+			return func(*http.Request) (*http.Response, error) {
+				return nil, fmt.Errorf("Error")
+			}
+		})
+		Ω(err).Should(HaveOccurred())
+		Ω(count).Should(Equal(1))
+	})
+})
@@ -0,0 +1,178 @@
+package apic
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timing is connection timing breakdown of a single request attempt
+type Timing struct {
+	Attempt         int           // retry loop attempt, 0 outside of retry interceptor
+	DNS             time.Duration // DNS lookup
+	Connect         time.Duration // TCP connect
+	TLS             time.Duration // TLS handshake
+	TimeToFirstByte time.Duration // from start of attempt to first response byte
+	Total           time.Duration // from start of attempt to response body close
+	Reused          bool          // connection was reused from pool
+	RemoteAddr      string
+}
+
+// TimingFunc receives attempt timing once it is complete
+type TimingFunc func(t Timing)
+
+// timingKey is context key of attempt timing
+type timingKey struct{}
+
+// timingRecorder collects timing from httptrace hooks called by transport goroutines
+type timingRecorder struct {
+	mu                     sync.Mutex
+	t                      Timing
+	start                  time.Time
+	dnsStart, connectStart time.Time
+	tlsStart               time.Time
+	done                   bool
+}
+
+// snapshot returns copy of recorded timing
+func (r *timingRecorder) snapshot() Timing {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.t
+}
+
+// update applies fn under lock
+func (r *timingRecorder) update(fn func(now time.Time)) {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn(now)
+}
+
+// trace returns httptrace hooks filling timing
+func (r *timingRecorder) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			r.update(func(now time.Time) { r.dnsStart = now })
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			r.update(func(now time.Time) { r.t.DNS = now.Sub(r.dnsStart) })
+		},
+		ConnectStart: func(string, string) {
+			r.update(func(now time.Time) {
+				// dialer may race several addresses, count from the first one
+				if r.connectStart.IsZero() {
+					r.connectStart = now
+				}
+			})
+		},
+		ConnectDone: func(_, _ string, err error) {
+			r.update(func(now time.Time) {
+				if err == nil {
+					r.t.Connect = now.Sub(r.connectStart)
+				}
+			})
+		},
+		TLSHandshakeStart: func() {
+			r.update(func(now time.Time) { r.tlsStart = now })
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			r.update(func(now time.Time) { r.t.TLS = now.Sub(r.tlsStart) })
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			r.update(func(time.Time) {
+				r.t.Reused = info.Reused
+				if info.Conn != nil {
+					r.t.RemoteAddr = info.Conn.RemoteAddr().String()
+				}
+			})
+		},
+		GotFirstResponseByte: func() {
+			r.update(func(now time.Time) { r.t.TimeToFirstByte = now.Sub(r.start) })
+		},
+	}
+}
+
+// finish sets total time and reports timing once
+func (r *timingRecorder) finish(fn TimingFunc) {
+	now := time.Now()
+	r.mu.Lock()
+	if r.done {
+		r.mu.Unlock()
+		return
+	}
+	r.done = true
+	r.t.Total = now.Sub(r.start)
+	t := r.t
+	r.mu.Unlock()
+	if fn != nil {
+		fn(t)
+	}
+}
+
+// finishOnClose completes timing once response body is closed
+type finishOnClose struct {
+	io.ReadCloser
+	finish func()
+}
+
+// Close closes body and completes timing
+func (b *finishOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.finish()
+	return err
+}
+
+// TimingFromContext returns timing of attempt the context belongs to
+func TimingFromContext(ctx context.Context) (Timing, bool) {
+	r, ok := ctx.Value(timingKey{}).(*timingRecorder)
+	if !ok {
+		return Timing{}, false
+	}
+	return r.snapshot(), true
+}
+
+// TimingFromResponse returns timing of attempt which produced response.
+// Total is known only after response body is closed.
+func TimingFromResponse(res *http.Response) (Timing, bool) {
+	if res == nil || res.Request == nil {
+		return Timing{}, false
+	}
+	return TimingFromContext(res.Request.Context())
+}
+
+// WithTiming records DNS, connect, TLS, time to first byte and total time of
+// every attempt with net/http/httptrace hooks. Timing is passed to callback
+// once response body is closed, or right away if request fails, and can be
+// retrieved from response with TimingFromResponse.
+// Usage example:
+//
+//	res, err := c.Do(req, WithTiming(func(t Timing) {
+//		log.Printf("attempt %d: dns %s, connect %s, tls %s, ttfb %s", t.Attempt, t.DNS, t.Connect, t.TLS, t.TimeToFirstByte)
+//	}))
+func WithTiming(fn TimingFunc) InterceptDoFunc {
+	return func(do DoFunc) DoFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			r := &timingRecorder{start: time.Now()}
+			r.t.Attempt = RetryAttempt(req.Context())
+			ctx := httptrace.WithClientTrace(req.Context(), r.trace())
+			ctx = context.WithValue(ctx, timingKey{}, r)
+			traced := req.WithContext(ctx)
+
+			res, err := do(traced)
+			if err != nil || res == nil || res.Body == nil {
+				r.finish(fn)
+				return res, err
+			}
+			if res.Request == nil {
+				res.Request = traced
+			}
+			res.Body = &finishOnClose{ReadCloser: res.Body, finish: func() { r.finish(fn) }}
+			return res, nil
+		}
+	}
+}
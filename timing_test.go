@@ -0,0 +1,75 @@
+package apic_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kolach/apic"
+)
+
+var _ = Describe("WithTiming", func() {
+	var server *httptest.Server
+
+	BeforeEach(func() {
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(10 * time.Millisecond)
+			fmt.Fprint(w, "test")
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should report timing breakdown of attempts", func() {
+		var timings []Timing
+		do := WithTiming(func(t Timing) { timings = append(timings, t) })(server.Client().Do)
+
+		for i := 0; i < 2; i++ {
+			req, _ := http.NewRequest("GET", server.URL, nil)
+			res, err := do(req)
+			Ω(err).ShouldNot(HaveOccurred())
+			ioutil.ReadAll(res.Body)
+			Ω(timings).Should(HaveLen(i))
+			res.Body.Close()
+		}
+
+		Ω(timings).Should(HaveLen(2))
+		first, second := timings[0], timings[1]
+		Ω(first.Reused).Should(BeFalse())
+		Ω(first.Connect).Should(BeNumerically(">", 0))
+		Ω(first.TLS).Should(BeNumerically(">", 0))
+		Ω(first.TimeToFirstByte).Should(BeNumerically(">=", 10*time.Millisecond))
+		Ω(first.Total).Should(BeNumerically(">=", first.TimeToFirstByte))
+		Ω(first.RemoteAddr).Should(Equal(server.Listener.Addr().String()))
+		Ω(second.Reused).Should(BeTrue())
+		Ω(second.TLS).Should(BeZero())
+	})
+
+	It("should make timing retrievable from response", func() {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		res, err := WithTiming(nil)(server.Client().Do)(req)
+		Ω(err).ShouldNot(HaveOccurred())
+		defer res.Body.Close()
+		t, ok := TimingFromResponse(res)
+		Ω(ok).Should(BeTrue())
+		Ω(t.TimeToFirstByte).Should(BeNumerically(">", 0))
+	})
+
+	It("should report timing of every retry attempt", func() {
+		var attempts []int
+		count := 0
+		req, _ := http.NewRequest("POST", server.URL, strings.NewReader("test"))
+		b := backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Millisecond), 2)
+		WithRetry(b)(WithTiming(func(t Timing) { attempts = append(attempts, t.Attempt) })(failWith(fmt.Errorf("Error"), &count)))(req)
+		Ω(attempts).Should(Equal([]int{1, 2, 3}))
+	})
+})
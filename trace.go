@@ -0,0 +1,192 @@
+package apic
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// W3C Trace Context headers
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+// Span attribute keys set by tracing
+const (
+	AttrMethod     = "http.method"
+	AttrURL        = "http.url"
+	AttrStatusCode = "http.status_code"
+	AttrAttempt    = "http.attempt"
+	AttrAttempts   = "http.attempts"
+)
+
+// SpanContext identifies span across process boundaries
+type SpanContext struct {
+	TraceID    [16]byte
+	SpanID     [8]byte
+	Sampled    bool
+	TraceState string
+}
+
+// IsValid tells if span context has non zero trace and span ids
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent formats span context as traceparent header value
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), flags)
+}
+
+// ParseTraceparent parses traceparent header value
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	traceID, err := hex.DecodeString(parts[1])
+	if err != nil || len(traceID) != 16 {
+		return sc, fmt.Errorf("invalid traceparent trace id %q", parts[1])
+	}
+	spanID, err := hex.DecodeString(parts[2])
+	if err != nil || len(spanID) != 8 {
+		return sc, fmt.Errorf("invalid traceparent span id %q", parts[2])
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return sc, fmt.Errorf("invalid traceparent flags %q", parts[3])
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	return sc, nil
+}
+
+// Span is a traced operation
+type Span interface {
+	// SpanContext returns span identity to propagate
+	SpanContext() SpanContext
+	// SetAttribute records span attribute
+	SetAttribute(key string, value interface{})
+	// RecordError records error of operation
+	RecordError(err error)
+	// End completes the span
+	End()
+}
+
+// Tracer starts spans, adapt it to OpenTelemetry or use NoopTracer to disable tracing
+type Tracer interface {
+	// Start starts span as child of span found in context and returns context holding new span
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// remoteSpanKey is context key of remote parent span context
+type remoteSpanKey struct{}
+
+// ContextWithRemoteSpanContext makes span context received from remote caller,
+// like one parsed from incoming traceparent header, parent of spans started by SimpleTracer
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteSpanKey{}, sc)
+}
+
+// callSpan is client call state shared with attempt spans
+type callSpan struct {
+	attempts int32
+}
+
+// callSpanKey is context key of client call state
+type callSpanKey struct{}
+
+// inject sets trace context headers of request
+func inject(req *http.Request, sc SpanContext) {
+	if !sc.IsValid() {
+		return
+	}
+	req.Header.Set(TraceparentHeader, sc.Traceparent())
+	if sc.TraceState != "" {
+		req.Header.Set(TracestateHeader, sc.TraceState)
+	} else {
+		req.Header.Del(TracestateHeader)
+	}
+}
+
+// endSpan records request outcome and ends span
+func endSpan(span Span, res *http.Response, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	if code := statusCode(res, err); code != 0 {
+		span.SetAttribute(AttrStatusCode, code)
+	}
+	span.End()
+}
+
+// WithTracing starts span per request attempt and propagates it
+// with traceparent and tracestate headers. Clients configured with WithTracer
+// add it automatically, together with span covering the whole call.
+// Usage example:
+//
+// tracer := NewSimpleTracer(exporter)
+// ...
+// res, err := c.Do(req, WithTracing(tracer))
+//
+func WithTracing(t Tracer) InterceptDoFunc {
+	return func(do DoFunc) DoFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			ctx, span := t.Start(req.Context(), "HTTP "+req.Method+" attempt")
+			span.SetAttribute(AttrMethod, req.Method)
+			span.SetAttribute(AttrURL, req.URL.String())
+			attempt := RetryAttempt(req.Context())
+			if call, ok := req.Context().Value(callSpanKey{}).(*callSpan); ok {
+				n := atomic.AddInt32(&call.attempts, 1)
+				if attempt == 0 {
+					attempt = int(n)
+				}
+			}
+			if attempt > 0 {
+				span.SetAttribute(AttrAttempt, attempt)
+			}
+
+			r := req.WithContext(ctx)
+			r.Header = req.Header.Clone()
+			if r.Header == nil {
+				r.Header = make(http.Header)
+			}
+			inject(r, span.SpanContext())
+
+			res, err := do(r)
+			endSpan(span, res, err)
+			return res, err
+		}
+	}
+}
+
+// withCallSpan starts span covering the whole client call including retries
+func withCallSpan(t Tracer) InterceptDoFunc {
+	return func(do DoFunc) DoFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			ctx, span := t.Start(req.Context(), "HTTP "+req.Method)
+			span.SetAttribute(AttrMethod, req.Method)
+			span.SetAttribute(AttrURL, req.URL.String())
+			call := new(callSpan)
+			ctx = context.WithValue(ctx, callSpanKey{}, call)
+
+			res, err := do(req.WithContext(ctx))
+			span.SetAttribute(AttrAttempts, int(atomic.LoadInt32(&call.attempts)))
+			endSpan(span, res, err)
+			return res, err
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package apic_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kolach/apic"
+)
+
+var _ = Describe("Tracing", func() {
+	var (
+		exporter *InMemoryExporter
+		tracer   *SimpleTracer
+		req      *http.Request
+		headers  []http.Header
+		statuses []int
+		do       DoFunc
+	)
+
+	BeforeEach(func() {
+		exporter = new(InMemoryExporter)
+		tracer = NewSimpleTracer(exporter)
+		req, _ = http.NewRequest("GET", "https://example.com/orders/1", nil)
+		headers, statuses = nil, []int{http.StatusServiceUnavailable, http.StatusOK}
+		do = func(req *http.Request) (*http.Response, error) {
+			headers = append(headers, req.Header)
+			status := statuses[0]
+			statuses = statuses[1:]
+			return &http.Response{StatusCode: status, Body: http.NoBody, Request: req}, nil
+		}
+	})
+
+	It("should parse and format traceparent", func() {
+		sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(sc.Sampled).Should(BeTrue())
+		Ω(sc.Traceparent()).Should(Equal("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"))
+
+		_, err = ParseTraceparent("00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+		Ω(err).Should(HaveOccurred())
+		_, err = ParseTraceparent("garbage")
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("should inject trace context of attempt span", func() {
+		statuses = []int{http.StatusOK}
+		parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		parent.TraceState = "vendor=value"
+		req = req.WithContext(ContextWithRemoteSpanContext(context.Background(), parent))
+
+		_, err := WithTracing(tracer)(do)(req)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(req.Header.Get(TraceparentHeader)).Should(BeEmpty())
+
+		spans := exporter.Spans()
+		Ω(spans).Should(HaveLen(1))
+		Ω(spans[0].Context.TraceID).Should(Equal(parent.TraceID))
+		Ω(spans[0].ParentID).Should(Equal(parent.SpanID))
+		Ω(spans[0].Attributes[AttrStatusCode]).Should(Equal(http.StatusOK))
+		Ω(headers[0].Get(TraceparentHeader)).Should(Equal(spans[0].Context.Traceparent()))
+		Ω(headers[0].Get(TracestateHeader)).Should(Equal("vendor=value"))
+	})
+
+	It("should trace client call with child span per attempt", func() {
+		client := NewClient(WithConstantBackOff(time.Millisecond), WithMaxRetries(2),
+			WithRetryOptions(WithRetryOnResponse(RetryOnServerError)), WithTracer(tracer),
+			WithHTTPClient(&http.Client{Transport: roundTripFunc(do)}))
+		res, err := client.Do(req)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(res.StatusCode).Should(Equal(http.StatusOK))
+
+		spans := exporter.Spans()
+		Ω(spans).Should(HaveLen(3))
+		call := spans[2]
+		Ω(call.Name).Should(Equal("HTTP GET"))
+		Ω(call.ParentID).Should(Equal([8]byte{}))
+		Ω(call.Attributes[AttrAttempts]).Should(Equal(2))
+		Ω(call.Attributes[AttrStatusCode]).Should(Equal(http.StatusOK))
+		for i, attempt := range spans[:2] {
+			Ω(attempt.Name).Should(Equal("HTTP GET attempt"))
+			Ω(attempt.Context.TraceID).Should(Equal(call.Context.TraceID))
+			Ω(attempt.ParentID).Should(Equal(call.Context.SpanID))
+			Ω(attempt.Attributes[AttrAttempt]).Should(Equal(i + 1))
+			Ω(headers[i].Get(TraceparentHeader)).Should(Equal(attempt.Context.Traceparent()))
+		}
+		Ω(spans[0].Attributes[AttrStatusCode]).Should(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("should record errors", func() {
+		do = func(req *http.Request) (*http.Response, error) { return nil, fmt.Errorf("Error") }
+		WithTracing(tracer)(do)(req)
+		Ω(exporter.Spans()[0].Errors).Should(ConsistOf(MatchError("Error")))
+	})
+
+	It("should work with no-op tracer", func() {
+		statuses = []int{http.StatusOK}
+		_, err := WithTracing(NoopTracer{})(do)(req)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(headers[0].Get(TraceparentHeader)).Should(BeEmpty())
+	})
+})
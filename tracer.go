@@ -0,0 +1,154 @@
+package apic
+
+import (
+	"context"
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+// NoopTracer is tracer starting spans which record nothing
+type NoopTracer struct{}
+
+// noopSpan is span recording nothing
+type noopSpan struct{}
+
+func (noopSpan) SpanContext() SpanContext         { return SpanContext{} }
+func (noopSpan) SetAttribute(string, interface{}) {}
+func (noopSpan) RecordError(error)                {}
+func (noopSpan) End()                             {}
+
+// Start returns context as is and span recording nothing
+func (NoopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+// SpanData is completed span
+type SpanData struct {
+	Name       string
+	Context    SpanContext
+	ParentID   [8]byte // zero for root spans
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	Errors     []error
+}
+
+// SpanExporter receives completed spans
+type SpanExporter interface {
+	Export(span SpanData)
+}
+
+// InMemoryExporter keeps completed spans in memory, useful in tests
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// Export stores span
+func (e *InMemoryExporter) Export(span SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+// Spans returns completed spans in order they ended
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+// Reset forgets stored spans
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+// spanKey is context key of active span
+type spanKey struct{}
+
+// simpleSpan is span of SimpleTracer
+type simpleSpan struct {
+	mu       sync.Mutex
+	data     SpanData
+	exporter SpanExporter
+	ended    bool
+}
+
+// SpanContext returns span identity
+func (s *simpleSpan) SpanContext() SpanContext {
+	return s.data.Context
+}
+
+// SetAttribute records span attribute
+func (s *simpleSpan) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes[key] = value
+}
+
+// RecordError records error of operation
+func (s *simpleSpan) RecordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Errors = append(s.data.Errors, err)
+}
+
+// End completes span and exports it, repeated calls do nothing
+func (s *simpleSpan) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+	s.exporter.Export(data)
+}
+
+// SimpleTracer is self-contained tracer generating W3C trace ids and exporting
+// completed spans to exporter
+type SimpleTracer struct {
+	exporter SpanExporter
+}
+
+// NewSimpleTracer constructs tracer exporting spans to given exporter
+func NewSimpleTracer(exporter SpanExporter) *SimpleTracer {
+	return &SimpleTracer{exporter: exporter}
+}
+
+// Start starts span as child of active span or remote span context found in ctx
+func (t *SimpleTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	var parent SpanContext
+	if s, ok := ctx.Value(spanKey{}).(*simpleSpan); ok {
+		parent = s.data.Context
+	} else if sc, ok := ctx.Value(remoteSpanKey{}).(SpanContext); ok {
+		parent = sc
+	}
+
+	sc := SpanContext{Sampled: true}
+	if parent.IsValid() {
+		sc.TraceID, sc.Sampled, sc.TraceState = parent.TraceID, parent.Sampled, parent.TraceState
+	} else {
+		rand.Read(sc.TraceID[:])
+	}
+	rand.Read(sc.SpanID[:])
+
+	span := &simpleSpan{
+		data: SpanData{
+			Name:       name,
+			Context:    sc,
+			Start:      time.Now(),
+			Attributes: make(map[string]interface{}),
+		},
+		exporter: t.exporter,
+	}
+	if parent.IsValid() {
+		span.data.ParentID = parent.SpanID
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}